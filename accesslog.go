@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Replace empty values with "-" as the log format expects:
+func logField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Write a line in Apache Combined Log Format:
+func (s *Server) logAccess(w *statusRecorder, req *http.Request, start time.Time) {
+	if s.accessLog == nil || s.logLevel < levelInfo {
+		return
+	}
+
+	host := s.clientAddr(req)
+	user, _, _ := req.BasicAuth()
+
+	if s.jsonLog != nil {
+		s.writeAccessJSON(w, req, start, host, user)
+		return
+	}
+
+	bytes := "-"
+	if w.Bytes() > 0 {
+		bytes = strconv.FormatInt(w.Bytes(), 10)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s %q %q\n",
+		logField(host),
+		logField(user),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method,
+		req.RequestURI,
+		req.Proto,
+		w.Status(),
+		bytes,
+		logField(req.Referer()),
+		logField(req.UserAgent()),
+	)
+	// Append the request ID as an extra quoted field, as nginx does with $request_id:
+	if id := requestID(req); id != "" {
+		line = line[:len(line)-1] + fmt.Sprintf(" %q\n", id)
+	}
+
+	s.accessLogLock.Lock()
+	defer s.accessLogLock.Unlock()
+	io.WriteString(s.accessLog, line)
+}
+
+// Write an access log entry as a JSON object for -log-format json:
+func (s *Server) writeAccessJSON(w *statusRecorder, req *http.Request, start time.Time, host, user string) {
+	b, err := json.Marshal(logEntry{
+		Time:      start.UTC().Format(time.RFC3339Nano),
+		Level:     "info",
+		Msg:       "access",
+		Method:    req.Method,
+		Path:      req.RequestURI,
+		Status:    w.Status(),
+		Remote:    host,
+		User:      user,
+		Bytes:     w.Bytes(),
+		Referer:   req.Referer(),
+		UserAgent: req.UserAgent(),
+		Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		RequestID: requestID(req),
+	})
+	if err != nil {
+		return
+	}
+
+	s.accessLogLock.Lock()
+	defer s.accessLogLock.Unlock()
+	s.accessLog.Write(append(b, '\n'))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+)
+
+// The methods accepted, including POST for -allow-admin:
+func (s *Server) allowedMethods() string {
+	if s.allowAdmin {
+		return allowedMethods + ", POST"
+	}
+	return allowedMethods
+}
+
+// Check that a state-changing request wasn't sent by another site's page, e.g. a cross-site form, since browsers
+// resend Basic credentials with those too. Requests without Sec-Fetch-Site or Origin headers don't come from
+// browsers, e.g. curl's, and are allowed:
+func (s *Server) isSameOrigin(req *http.Request) bool {
+	if site := req.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	if origin := req.Header.Get("Origin"); origin != "" {
+		scheme, host := s.requestOrigin(req)
+		return origin == scheme+"://"+host
+	}
+	return true
+}
+
+// Pin a directory's sort order by writing its .index-sort file, for POST ?set-sort=<sort-method>:
+func (s *Server) serveSetSort(rsp http.ResponseWriter, req *http.Request, u *url.URL, localPath string, fi os.FileInfo) {
+	// Only authenticated users may change anything; auth may have been removed by a reload:
+	if s.live().auth == nil {
+		s.doError(req, rsp, "Admin requests require -auth-user or -htpasswd", http.StatusForbidden)
+		return
+	}
+	if !s.isSameOrigin(req) {
+		s.doError(req, rsp, "Cross-origin admin request refused", http.StatusForbidden)
+		return
+	}
+	if !fi.IsDir() {
+		s.doError(req, rsp, "Sort can only be set on directories", http.StatusBadRequest)
+		return
+	}
+	sortString := u.Query().Get("set-sort")
+	if _, _, ok := parseSort(sortString); !ok {
+		s.doError(req, rsp, fmt.Sprintf("Invalid set-sort '%s': expected name, date or size followed by -asc or -desc", sortString), http.StatusBadRequest)
+		return
+	}
+
+	// Write a temporary file and rename it over the old one, so readers never see a partial file:
+	tmp, err := os.CreateTemp(localPath, indexSortFile+".*")
+	if err != nil {
+		s.doFileError(req, rsp, err)
+		return
+	}
+	_, err = fmt.Fprintln(tmp, sortString)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path.Join(localPath, indexSortFile))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		s.doError(req, rsp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Send browsers back to the listing:
+	doRedirect(req, rsp, u.EscapedPath(), http.StatusSeeOther)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"time"
+)
+
+// CSS and JavaScript used by listings, built into the binary so it's self-contained:
+//
+//go:embed assets
+var assetsFS embed.FS
+
+// Embedded files carry no modification time, so use the process start for caching headers:
+var assetsModTime = time.Now()
+
+// Read an embedded asset for inlining; the names are fixed at build time:
+func readAsset(name string) string {
+	b, err := fs.ReadFile(assetsFS, path.Join("assets", name))
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// Serve an embedded asset by its name under the assets prefix:
+func (s *Server) serveAsset(rsp http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		rsp.Header().Set("Allow", "GET, HEAD")
+		s.doError(req, rsp, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f, err := assetsFS.Open(path.Join("assets", path.Clean("/"+name)))
+	if err != nil {
+		s.doError(req, rsp, "No such asset: "+name, http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		s.doError(req, rsp, "No such asset: "+name, http.StatusNotFound)
+		return
+	}
+
+	rsp.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeContent(rsp, req, fi.Name(), assetsModTime, f.(io.ReadSeeker))
+}
@@ -0,0 +1,69 @@
+// Sorts the rendered listing in place using the cells' raw data-* values.
+// Directories stay first unless the table has data-group="none", matching the server-side
+// sorters, and the header links are updated so each one toggles its direction just like
+// the ?sort= links do. Only the sort parameter of a link changes, so filters like ?only= are kept.
+(function() {
+  var tbody = document.querySelector("table tbody");
+  var links = document.querySelectorAll("thead th a");
+  var defaults = {name: "name-asc", size: "size-asc", date: "date-asc"};
+  var keys = {
+    name: function(r) { return r.querySelector("td.name").getAttribute("data-name"); },
+    size: function(r) { return Math.max(0, Number(r.querySelector("td.size").getAttribute("data-size"))); },
+    date: function(r) { return Number(r.querySelector("td.modified").getAttribute("data-modified")); }
+  };
+  var mixed = tbody.parentNode.getAttribute("data-group") === "none";
+  var isDir = function(r) { return r.querySelector("td.type").getAttribute("data-type") === "directory"; };
+
+  Array.prototype.forEach.call(links, function(a) {
+    a.addEventListener("click", function(ev) {
+      var m = /sort=(name|size|date)-(asc|desc)/.exec(a.getAttribute("href"));
+      if (!m) {
+        return;
+      }
+      ev.preventDefault();
+
+      var key = keys[m[1]], sign = m[2] === "asc" ? 1 : -1;
+      var rows = Array.prototype.filter.call(tbody.rows, function(r) {
+        return r.querySelector("td.name[data-name]") !== null;
+      });
+      rows.sort(function(x, y) {
+        var dx = isDir(x), dy = isDir(y);
+        if (!mixed && dx !== dy) {
+          return dx ? -1 : 1;
+        }
+        var kx = key(x), ky = key(y);
+        return kx < ky ? -sign : kx > ky ? sign : 0;
+      });
+      // Keep any truncation notice last:
+      var notice = tbody.querySelector("td.truncated");
+      rows.forEach(function(r) { tbody.insertBefore(r, notice ? notice.parentNode : null); });
+
+      Array.prototype.forEach.call(links, function(l) {
+        var c = /sort=(name|size|date)-(asc|desc)/.exec(l.getAttribute("href"));
+        if (c) {
+          l.setAttribute("href", l.getAttribute("href").replace(c[0], "sort=" + defaults[c[1]]));
+        }
+        var arrow = l.parentNode.querySelector(".sort-arrow");
+        if (arrow) {
+          // Take the separating space with it:
+          if (arrow.previousSibling && arrow.previousSibling.nodeType === 3) {
+            l.parentNode.removeChild(arrow.previousSibling);
+          }
+          l.parentNode.removeChild(arrow);
+        }
+        l.parentNode.removeAttribute("aria-sort");
+      });
+      if (m[2] === "asc") {
+        a.setAttribute("href", a.getAttribute("href").replace("sort=" + defaults[m[1]], "sort=" + m[1] + "-desc"));
+      }
+
+      // Move the direction indicator to the newly sorted column:
+      var arrow = document.createElement("span");
+      arrow.className = "sort-arrow";
+      arrow.textContent = m[2] === "asc" ? "\u25B2" : "\u25BC";
+      a.parentNode.appendChild(document.createTextNode(" "));
+      a.parentNode.appendChild(arrow);
+      a.parentNode.setAttribute("aria-sort", m[2] === "asc" ? "ascending" : "descending");
+    });
+  });
+})();
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// Accepted HTTP Basic credentials, mapping user names to "{SHA}<base64 sha1>" or plaintext passwords:
+type credentials map[string]string
+
+// Load an htpasswd file; only {SHA} and plaintext passwords are supported.
+func loadHtpasswd(filename string) (credentials, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	creds := credentials{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:password", filename, n)
+		}
+		if startsWith(parts[1], "$") {
+			return nil, fmt.Errorf("%s:%d: unsupported password hash; use {SHA} (htpasswd -s) or plaintext", filename, n)
+		}
+		creds[parts[0]] = parts[1]
+	}
+	return creds, scanner.Err()
+}
+
+// Compare two strings in constant time regardless of their lengths:
+func secureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+func (c credentials) check(user, pass string) bool {
+	want, ok := c[user]
+	if !ok {
+		// Do the same amount of work for unknown users:
+		secureCompare(pass, pass)
+		return false
+	}
+
+	if startsWith(want, "{SHA}") {
+		sum := sha1.Sum([]byte(pass))
+		return secureCompare("{SHA}"+base64.StdEncoding.EncodeToString(sum[:]), want)
+	}
+	return secureCompare(pass, want)
+}
+
+// Check the request's Basic credentials, replying 401 if they're missing or wrong:
+func (s *Server) checkAuth(rsp http.ResponseWriter, req *http.Request, creds credentials, realm string) bool {
+	if user, pass, ok := req.BasicAuth(); ok && creds.check(user, pass) {
+		return true
+	}
+
+	rsp.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+	s.doError(req, rsp, "Authentication required", http.StatusUnauthorized)
+	return false
+}
+
+// Name of the per-directory access control file:
+const indexAuthFile = ".index-auth"
+
+// Parse an .index-auth file. Each line is one of:
+//
+//	realm <name>
+//	htpasswd <file>   (relative to the .index-auth file's directory)
+//	<user>:<password>
+func loadIndexAuth(filename string) (creds credentials, realm string, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	creds = credentials{}
+	realm = "index-html"
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if startsWith(line, "realm ") {
+			realm = strings.TrimSpace(line[len("realm "):])
+		} else if startsWith(line, "htpasswd ") {
+			htpasswd := strings.TrimSpace(line[len("htpasswd "):])
+			if !path.IsAbs(htpasswd) {
+				htpasswd = path.Join(path.Dir(filename), htpasswd)
+			}
+			more, err := loadHtpasswd(htpasswd)
+			if err != nil {
+				return nil, "", err
+			}
+			for user, pass := range more {
+				creds[user] = pass
+			}
+		} else if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && parts[0] != "" {
+			creds[parts[0]] = parts[1]
+		} else {
+			return nil, "", fmt.Errorf("%s:%d: expected realm, htpasswd or user:password", filename, n)
+		}
+	}
+	return creds, realm, scanner.Err()
+}
+
+// Enforce the nearest .index-auth file at or above localPath within the mount's jail:
+func (s *Server) checkIndexAuth(rsp http.ResponseWriter, req *http.Request, m *mount, localPath string) bool {
+	dir := localPath
+	if fi, err := os.Stat(localPath); err != nil || !fi.IsDir() {
+		dir = path.Dir(localPath)
+	}
+
+	for m.isInsideJail(dir) {
+		authPath := path.Join(dir, indexAuthFile)
+		if _, err := os.Stat(authPath); err == nil {
+			creds, realm, err := loadIndexAuth(authPath)
+			if err != nil {
+				// Fail closed rather than exposing the subtree:
+				s.doError(req, rsp, err.Error(), http.StatusInternalServerError)
+				return false
+			}
+			return s.checkAuth(rsp, req, creds, realm)
+		}
+
+		parent := path.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return true
+}
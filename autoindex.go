@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Media type of the autoindex JSON format, which clients may ask for in the Accept header:
+const autoindexMediaType = "application/vnd.index-html.autoindex+json"
+
+// Version of the autoindex JSON format; bump it on incompatible changes:
+const autoindexVersion = 1
+
+type autoindexEntry struct {
+	Name      string `json:"name"`
+	Href      string `json:"href"`  // percent-encoded; directories end in "/"
+	Size      *int64 `json:"size"`  // bytes; null except for regular files
+	Mtime     *int64 `json:"mtime"` // Unix seconds; null if the entry couldn't be read
+	IsDir     bool   `json:"is_dir"`
+	IsSymlink bool   `json:"is_symlink"`
+	Mime      string `json:"mime"` // the Content-Type a file is served with; empty for anything else
+}
+
+type autoindexListing struct {
+	Version    int              `json:"version"`
+	Path       string           `json:"path"`
+	Href       string           `json:"href"`   // percent-encoded, ending in "/"
+	Parent     *string          `json:"parent"` // percent-encoded; null at the root of a mount
+	Mtime      int64            `json:"mtime"`  // the directory's own, Unix seconds
+	Sort       string           `json:"sort"`
+	EntryCount int              `json:"entry_count"` // including any left out by -max-entries
+	DirCount   int              `json:"dir_count"`
+	FileCount  int              `json:"file_count"`
+	TotalSize  int64            `json:"total_size"` // bytes, of regular files
+	Omitted    int              `json:"omitted"`    // entries left out by -max-entries
+	Entries    []autoindexEntry `json:"entries"`
+}
+
+// Check if the client asked for the autoindex format in its Accept header:
+func acceptsAutoindex(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), autoindexMediaType)
+}
+
+// Render a directory index as an autoindex JSON object, with every field always present, for file browsers
+// that render listings themselves:
+func (s *Server) writeAutoindex(buf *bytes.Buffer, page *indexPage) {
+	dirPath := page.pathLink
+	if !strings.HasSuffix(dirPath, "/") {
+		dirPath += "/"
+	}
+	listing := autoindexListing{
+		Version:    autoindexVersion,
+		Path:       page.pathLink,
+		Href:       escapePath(dirPath),
+		Mtime:      page.dirInfo.ModTime().Unix(),
+		Sort:       page.sortString(),
+		EntryCount: page.summary.entries,
+		DirCount:   page.summary.dirs,
+		FileCount:  page.summary.files,
+		TotalSize:  page.summary.totalSize,
+		Omitted:    page.omitted,
+		Entries:    make([]autoindexEntry, 0, len(page.entries)),
+	}
+	if page.showParent {
+		parent := escapePath(strings.TrimSuffix(path.Dir(page.pathLink), "/") + "/")
+		listing.Parent = &parent
+	}
+
+	for _, e := range page.entries {
+		kind := e.kind()
+		ae := autoindexEntry{Name: e.name, IsSymlink: e.followed != "" || kind == "symlink"}
+		href := e.href
+		if e.err == nil {
+			mtime := e.fi.ModTime().Unix()
+			ae.Mtime = &mtime
+			switch kind {
+			case "directory":
+				ae.IsDir = true
+				href += "/"
+			case "file":
+				size := e.fi.Size()
+				ae.Size = &size
+				if ae.Mime = mime.TypeByExtension(path.Ext(e.fi.Name())); ae.Mime == "" {
+					ae.Mime = s.defaultContentType
+				}
+			}
+		}
+		ae.Href = escapePath(href)
+		listing.Entries = append(listing.Entries, ae)
+	}
+
+	buf.WriteString(marshal(listing))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// A rendered directory listing:
+type cachedListing struct {
+	contentType string
+	body        []byte
+	modTime     time.Time // the directory's modification time when rendered
+	stored      time.Time
+}
+
+// Look up a rendered listing that is still valid for a directory last modified at modTime:
+func (s *Server) cachedListing(key string, modTime time.Time) *cachedListing {
+	if s.listingCache == nil {
+		return nil
+	}
+
+	v, ok := s.listingCache.Get(key)
+	if !ok {
+		return nil
+	}
+	c := v.(*cachedListing)
+
+	// NOTE: a directory's modtime only changes when entries are added, removed or renamed, so the TTL bounds how
+	// long changes to the entries themselves (e.g. a file growing) go unnoticed.
+	if !c.modTime.Equal(modTime) || (s.listingCacheTTL > 0 && time.Since(c.stored) > s.listingCacheTTL) {
+		return nil
+	}
+	return c
+}
+
+func (s *Server) cacheListing(key string, modTime time.Time, contentType string, body []byte) {
+	if s.listingCache == nil {
+		return
+	}
+
+	s.listingCache.Add(key, &cachedListing{
+		contentType: contentType,
+		body:        body,
+		modTime:     modTime,
+		stored:      time.Now(),
+	})
+}
+
+// Make a weak ETag for a rendered listing; weak, since it's the same whether or not the body is compressed:
+func listingETag(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return fmt.Sprintf(`W/"%016x"`, h.Sum64())
+}
+
+// Check an If-None-Match header against an ETag, using the weak comparison it calls for:
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
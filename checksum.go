@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+)
+
+// Create a hash for a supported checksum algorithm name:
+func newChecksumHash(algo string) hash.Hash {
+	switch algo {
+	case "sha256":
+		return sha256.New()
+	case "md5":
+		return md5.New()
+	}
+	return nil
+}
+
+// Reads from r until ctx is done, so hashing a large file stops once the client has gone away:
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// Compute (or recall) the hex checksum of a regular file:
+func (s *Server) fileChecksum(ctx context.Context, algo, localPath string, fi os.FileInfo) (string, error) {
+	key := fmt.Sprintf("%s:%s:%d:%d", algo, localPath, fi.ModTime().UnixNano(), fi.Size())
+	if sum, ok := s.checksumCache.Get(key); ok {
+		return sum.(string), nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := newChecksumHash(algo)
+	if _, err := io.Copy(h, contextReader{ctx, f}); err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	s.checksumCache.Add(key, sum)
+	return sum, nil
+}
+
+// Reply with a file's checksum in the same format as `sha256sum`/`md5sum`:
+func (s *Server) serveChecksum(rsp http.ResponseWriter, req *http.Request, algo, localPath string, fi os.FileInfo) {
+	if newChecksumHash(algo) == nil {
+		s.doError(req, rsp, fmt.Sprintf("Unsupported checksum algorithm '%s'", algo), http.StatusBadRequest)
+		return
+	}
+
+	sum, err := s.fileChecksum(req.Context(), algo, localPath, fi)
+	if err == context.Canceled {
+		// The client went away; there's nobody to reply to:
+		return
+	} else if err != nil {
+		s.doFileError(req, rsp, err)
+		return
+	}
+
+	rsp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rsp.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		fmt.Fprintf(rsp, "%s  %s\n", sum, path.Base(localPath))
+	}
+}
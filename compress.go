@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Listings smaller than this aren't worth compressing:
+const compressMinSize = 1024
+
+// Compress a rendered listing if the client accepts it, returning the body to send and its Content-Encoding.
+// Brotli compresses listings better, so it's preferred when both are equally acceptable:
+func (s *Server) compressListing(req *http.Request, body []byte) ([]byte, string) {
+	if !s.compress {
+		return body, ""
+	}
+	ranked := rankEncodings(req, []string{"br", "gzip", "identity"})
+	if len(ranked) == 0 || ranked[0] == "identity" {
+		return body, ""
+	}
+	encoding := ranked[0]
+	// Small listings go uncompressed unless the client refuses identity, e.g. with "identity;q=0":
+	if len(body) < compressMinSize && len(ranked) > 1 {
+		return body, ""
+	}
+
+	buf := &bytes.Buffer{}
+	var zw io.WriteCloser
+	if encoding == "br" {
+		zw = brotli.NewWriter(buf)
+	} else {
+		zw = gzip.NewWriter(buf)
+	}
+	if _, err := zw.Write(body); err != nil {
+		return body, ""
+	}
+	if err := zw.Close(); err != nil {
+		return body, ""
+	}
+	return buf.Bytes(), encoding
+}
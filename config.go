@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Collect the flags given on the command line, which take precedence over the config file:
+func commandLineFlags() map[string]bool {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// Config file keys that are spelled differently from their flags:
+var configAliases = map[string]string{
+	"proxyRoot":     "p",
+	"jailRoot":      "r",
+	"accelRedirect": "xa",
+	"mounts":        "mount",
+}
+
+// Apply a JSON config file whose keys are flag names (or their aliases above), leaving the explicit flags given on
+// the command line alone. If only is non-nil, other flags are left alone too. Repeatable flags such as "listen"
+// and "mount" take a list.
+func loadConfigFile(filename string, explicit, only map[string]bool) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+
+	// When reloading, a key removed from the file reverts to its default:
+	for name := range only {
+		if f := flag.Lookup(name); f != nil && !explicit[name] {
+			flag.Set(name, f.DefValue)
+		}
+	}
+
+	// Apply keys in a stable order so errors are reproducible:
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := key
+		if alias, ok := configAliases[key]; ok {
+			name = alias
+		}
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("%s: unknown key %q", filename, key)
+		}
+		if explicit[name] || (only != nil && !only[name]) {
+			continue
+		}
+
+		settings, err := configValues(values[key], isRepeatableFlag(f))
+		if err != nil {
+			return fmt.Errorf("%s: %s: %s", filename, key, err)
+		}
+		for _, v := range settings {
+			if err := flag.Set(name, v); err != nil {
+				return fmt.Errorf("%s: %s: %s", filename, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func isRepeatableFlag(f *flag.Flag) bool {
+	switch f.Value.(type) {
+	case *listenList, *mountList, *sortHintList:
+		return true
+	}
+	return false
+}
+
+// Convert a JSON value to the flag values it sets; only repeatable flags accept a list:
+func configValues(raw json.RawMessage, repeatable bool) ([]string, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	if list, ok := v.([]interface{}); ok {
+		if !repeatable {
+			return nil, fmt.Errorf("expected a single value, not a list")
+		}
+		settings := make([]string, 0, len(list))
+		for _, item := range list {
+			s, err := configScalar(item)
+			if err != nil {
+				return nil, err
+			}
+			settings = append(settings, s)
+		}
+		return settings, nil
+	}
+
+	s, err := configScalar(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+func configScalar(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("expected a string, number or boolean")
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The aggregate size of a directory subtree, as cached by the directory's modification time:
+type duTotal struct {
+	size     int64 // bytes, of regular files
+	files    int
+	dirs     int
+	complete bool // false if -du-max-entries stopped the walk
+	modTime  time.Time
+	stored   time.Time
+}
+
+// A directory in a ?format=du report:
+type duNode struct {
+	Name     string    `json:"name"`
+	Href     string    `json:"href"` // percent-encoded
+	Size     int64     `json:"size"` // bytes, of regular files in the whole subtree
+	Files    int       `json:"files"`
+	Dirs     int       `json:"dirs"`
+	Complete bool      `json:"complete"`
+	Children []*duNode `json:"children,omitempty"`
+}
+
+// Add up a directory subtree without following symlinks, leaving out dot-prefixed names and subtrees with their
+// own .index-auth file. budget counts down the entries left to visit, and memo records every subtree's total so one
+// report never walks (or spends budget on) a subtree twice, even with the cache off.
+func (s *Server) duTotal(ctx context.Context, localPath string, budget *int, memo map[string]duTotal) (duTotal, error) {
+	if t, ok := memo[localPath]; ok {
+		return t, nil
+	}
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return duTotal{}, err
+	}
+	// NOTE: changes deeper in the subtree, or to file sizes, don't change the directory's modtime, so only
+	// -du-cache-ttl bounds how long they go unnoticed.
+	if s.duCache != nil {
+		if v, ok := s.duCache.Get(localPath); ok {
+			c := v.(duTotal)
+			if c.modTime.Equal(fi.ModTime()) && time.Since(c.stored) <= s.duCacheTTL {
+				memo[localPath] = c
+				return c, nil
+			}
+		}
+	}
+
+	des, err := os.ReadDir(localPath)
+	if err != nil {
+		return duTotal{}, err
+	}
+
+	t := duTotal{complete: true, modTime: fi.ModTime()}
+	for _, de := range des {
+		if ctx.Err() != nil {
+			return duTotal{}, ctx.Err()
+		}
+		if strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		if *budget--; *budget < 0 {
+			t.complete = false
+			break
+		}
+
+		if de.IsDir() {
+			p := path.Join(localPath, de.Name())
+			if _, err := os.Lstat(path.Join(p, indexAuthFile)); err == nil {
+				continue
+			}
+			sub, err := s.duTotal(ctx, p, budget, memo)
+			if ctx.Err() != nil {
+				return duTotal{}, ctx.Err()
+			}
+			if err != nil {
+				// Leave out unreadable subtrees rather than failing the whole report:
+				continue
+			}
+			t.size += sub.size
+			t.files += sub.files
+			t.dirs += sub.dirs + 1
+			t.complete = t.complete && sub.complete
+		} else if de.Type().IsRegular() {
+			if info, err := de.Info(); err == nil {
+				t.size += info.Size()
+				t.files++
+			}
+		}
+	}
+
+	if t.complete && s.duCache != nil {
+		t.stored = time.Now()
+		s.duCache.Add(localPath, t)
+	}
+	memo[localPath] = t
+	return t, nil
+}
+
+// Build the report for a directory, listing its subdirectories down to depth, largest first:
+func (s *Server) duTree(ctx context.Context, localPath, name, href string, depth int, budget *int, memo map[string]duTotal) (*duNode, error) {
+	t, err := s.duTotal(ctx, localPath, budget, memo)
+	if err != nil {
+		return nil, err
+	}
+	n := &duNode{Name: name, Href: escapePath(href), Size: t.size, Files: t.files, Dirs: t.dirs, Complete: t.complete}
+	if depth == 0 {
+		return n, nil
+	}
+
+	des, err := os.ReadDir(localPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, de := range des {
+		if !de.IsDir() || strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		p := path.Join(localPath, de.Name())
+		if _, err := os.Lstat(path.Join(p, indexAuthFile)); err == nil {
+			continue
+		}
+		child, err := s.duTree(ctx, p, de.Name(), path.Join(href, de.Name())+"/", depth-1, budget, memo)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == nil {
+			n.Children = append(n.Children, child)
+		}
+	}
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		return n.Children[i].Size > n.Children[j].Size
+	})
+	return n, nil
+}
+
+// Write a du-style report: one line per directory with its size in bytes and indented name:
+func writeDiskUsage(buf *bytes.Buffer, n *duNode, indent string) {
+	size := strconv.FormatInt(n.Size, 10)
+	if !n.Complete {
+		// Counting stopped at -du-max-entries; the true size is at least this:
+		size = ">=" + size
+	}
+	name := n.Name
+	if !strings.HasSuffix(name, "/") {
+		name += "/"
+	}
+	fmt.Fprintf(buf, "%s\t%s%s\n", size, indent, name)
+	for _, c := range n.Children {
+		writeDiskUsage(buf, c, indent+"  ")
+	}
+}
+
+// Answer ?format=du (text) or ?format=du-json on a directory with the sizes of its subtrees:
+func (s *Server) serveDiskUsage(rsp http.ResponseWriter, req *http.Request, u *url.URL, localPath, pathLink, format string) {
+	depth := 1
+	if d := u.Query().Get("depth"); d != "" {
+		var err error
+		if depth, err = strconv.Atoi(d); err != nil || depth < 0 || depth > s.duMaxDepth {
+			s.doError(req, rsp, fmt.Sprintf("Invalid depth '%s': expected 0 to %d", d, s.duMaxDepth), http.StatusBadRequest)
+			return
+		}
+	}
+
+	dirPath := pathLink
+	if !strings.HasSuffix(dirPath, "/") {
+		dirPath += "/"
+	}
+	budget := s.duMaxEntries
+	root, err := s.duTree(req.Context(), localPath, dirPath, dirPath, depth, &budget, map[string]duTotal{})
+	if err != nil {
+		s.doReadDirError(req, rsp, err)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	contentType := "text/plain; charset=utf-8"
+	if format == "du-json" {
+		contentType = "application/json; charset=utf-8"
+		buf.WriteString(marshal(root))
+	} else {
+		writeDiskUsage(buf, root, "")
+	}
+
+	s.addCorsHeaders(rsp, req)
+	s.addSecurityHeaders(rsp)
+	rsp.Header().Set("Content-Type", contentType)
+	rsp.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	rsp.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		buf.WriteTo(rsp)
+	}
+}
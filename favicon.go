@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+)
+
+// Load the -favicon: "" for none, "builtin" for the embedded icon, or a file path:
+func loadFavicon(value string) (data []byte, name string, err error) {
+	switch value {
+	case "":
+		return nil, "", nil
+	case "builtin":
+		return []byte(readAsset("favicon.ico")), "favicon.ico", nil
+	}
+	data, err = os.ReadFile(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, path.Base(value), nil
+}
+
+// Answer favicon requests without touching the mounts, with 204 No Content if no favicon is configured:
+func (s *Server) serveFavicon(rsp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		rsp.Header().Set("Allow", "GET, HEAD")
+		s.doError(req, rsp, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Let browsers remember the answer either way so they stop asking:
+	rsp.Header().Set("Cache-Control", "public, max-age=86400")
+	if s.favicon == nil {
+		rsp.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(s.faviconName))
+	if contentType == "" {
+		contentType = http.DetectContentType(s.favicon)
+	}
+	rsp.Header().Set("Content-Type", contentType)
+	http.ServeContent(rsp, req, s.faviconName, assetsModTime, bytes.NewReader(s.favicon))
+}
+
+// Check if a request path names a regular file in its mount, so an unconfigured favicon doesn't hide it:
+func (s *Server) mountHasFile(p string) bool {
+	m := s.findMount(p)
+	if m == nil || isUnsafePath(p) {
+		return false
+	}
+	fi, err := os.Stat(path.Join(m.jailRoot, removeIfStartsWith(p, m.proxyRoot)))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// Link listings to the favicon, if one is configured:
+func (s *Server) faviconLink() string {
+	if s.favicon == nil || s.faviconPath == "" {
+		return ""
+	}
+	return fmt.Sprintf("    <link rel=\"icon\" href=\"%s\">\n", html.EscapeString(s.faviconPath))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	GUID      string       `xml:"guid"`
+	PubDate   string       `xml:"pubDate"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name  `xml:"rss"`
+	Version string    `xml:"version,attr"`
+	Title   string    `xml:"channel>title"`
+	Link    string    `xml:"channel>link"`
+	Desc    string    `xml:"channel>description"`
+	Items   []rssItem `xml:"channel>item"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// Build an absolute URL for a proxy path from the request's scheme and host:
+func (s *Server) absoluteURL(req *http.Request, p string) string {
+	scheme, host := s.requestOrigin(req)
+	return (&url.URL{Scheme: scheme, Host: host, Path: p}).String()
+}
+
+// Render the files of a directory index as an RSS or Atom feed, returning the content type:
+func (s *Server) writeFeed(buf *bytes.Buffer, req *http.Request, page *indexPage, format string) (string, error) {
+	dirPath := page.pathLink
+	if !strings.HasSuffix(dirPath, "/") {
+		dirPath += "/"
+	}
+	dirURL := s.absoluteURL(req, dirPath)
+
+	// Only regular files become feed items:
+	var files []indexEntry
+	for _, e := range page.entries {
+		if e.err == nil && e.fi.Mode().IsRegular() {
+			files = append(files, e)
+		}
+	}
+
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+
+	if format == "atom" {
+		feed := atomFeed{
+			Title:   "Index of " + page.pathLink,
+			ID:      dirURL,
+			Updated: page.dirInfo.ModTime().UTC().Format(time.RFC3339),
+			Links:   []atomLink{{Href: dirURL, Rel: "alternate", Type: "text/html"}},
+		}
+		for _, e := range files {
+			link := s.absoluteURL(req, e.href)
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:   e.name,
+				ID:      link,
+				Updated: e.fi.ModTime().UTC().Format(time.RFC3339),
+				Links: []atomLink{
+					{Href: link, Rel: "alternate"},
+					{Href: link, Rel: "enclosure", Type: mime.TypeByExtension(path.Ext(e.fi.Name())), Length: strconv.FormatInt(e.fi.Size(), 10)},
+				},
+			})
+		}
+		return "application/atom+xml; charset=utf-8", enc.Encode(feed)
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		Title:   "Index of " + page.pathLink,
+		Link:    dirURL,
+		Desc:    "Files in " + page.pathLink,
+	}
+	for _, e := range files {
+		link := s.absoluteURL(req, e.href)
+		feed.Items = append(feed.Items, rssItem{
+			Title:     e.name,
+			Link:      link,
+			GUID:      link,
+			PubDate:   e.fi.ModTime().UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{URL: link, Length: e.fi.Size(), Type: mime.TypeByExtension(path.Ext(e.fi.Name()))},
+		})
+	}
+	return "application/rss+xml; charset=utf-8", enc.Encode(feed)
+}
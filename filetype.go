@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+)
+
+// Readable names for common MIME types, shown in the Type column with -friendly-types:
+var friendlyTypes = map[string]string{
+	"text/plain":      "Text File",
+	"text/html":       "HTML Document",
+	"text/css":        "CSS Stylesheet",
+	"text/csv":        "CSV File",
+	"text/markdown":   "Markdown Document",
+	"text/xml":        "XML Document",
+	"text/javascript": "JavaScript File",
+
+	"application/json":       "JSON File",
+	"application/xml":        "XML Document",
+	"application/javascript": "JavaScript File",
+	"application/pdf":        "PDF Document",
+	"application/epub+zip":   "EPUB Book",
+	"application/wasm":       "WebAssembly Module",
+	"application/x-sh":       "Shell Script",
+
+	"application/zip":              "ZIP Archive",
+	"application/gzip":             "Gzip Archive",
+	"application/x-gzip":           "Gzip Archive",
+	"application/x-tar":            "Tar Archive",
+	"application/x-bzip2":          "Bzip2 Archive",
+	"application/x-xz":             "XZ Archive",
+	"application/zstd":             "Zstandard Archive",
+	"application/x-7z-compressed":  "7-Zip Archive",
+	"application/vnd.rar":          "RAR Archive",
+	"application/x-rar-compressed": "RAR Archive",
+	"application/x-iso9660-image":  "Disc Image",
+
+	"application/msword": "Word Document",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": "Word Document",
+	"application/vnd.ms-excel": "Excel Spreadsheet",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         "Excel Spreadsheet",
+	"application/vnd.ms-powerpoint":                                             "PowerPoint Presentation",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "PowerPoint Presentation",
+	"application/vnd.oasis.opendocument.text":                                   "OpenDocument Text",
+	"application/vnd.oasis.opendocument.spreadsheet":                            "OpenDocument Spreadsheet",
+	"application/vnd.oasis.opendocument.presentation":                           "OpenDocument Presentation",
+
+	"image/jpeg":               "JPEG Image",
+	"image/png":                "PNG Image",
+	"image/gif":                "GIF Image",
+	"image/webp":               "WebP Image",
+	"image/avif":               "AVIF Image",
+	"image/svg+xml":            "SVG Image",
+	"image/bmp":                "Bitmap Image",
+	"image/tiff":               "TIFF Image",
+	"image/x-icon":             "Icon",
+	"image/vnd.microsoft.icon": "Icon",
+
+	"audio/mpeg":   "MP3 Audio",
+	"audio/mp4":    "MPEG-4 Audio",
+	"audio/aac":    "AAC Audio",
+	"audio/flac":   "FLAC Audio",
+	"audio/ogg":    "Ogg Audio",
+	"audio/opus":   "Opus Audio",
+	"audio/wav":    "WAV Audio",
+	"audio/x-wav":  "WAV Audio",
+	"audio/x-flac": "FLAC Audio",
+
+	"video/mp4":        "MP4 Video",
+	"video/webm":       "WebM Video",
+	"video/x-matroska": "Matroska Video",
+	"video/quicktime":  "QuickTime Video",
+	"video/x-msvideo":  "AVI Video",
+	"video/mpeg":       "MPEG Video",
+	"video/ogg":        "Ogg Video",
+
+	"font/ttf":   "TrueType Font",
+	"font/otf":   "OpenType Font",
+	"font/woff":  "Web Font",
+	"font/woff2": "Web Font",
+}
+
+// Look up the readable name of a MIME type, ignoring parameters such as charset:
+func friendlyType(mimeType string) (string, bool) {
+	base, _, _ := strings.Cut(mimeType, ";")
+	name, ok := friendlyTypes[strings.ToLower(strings.TrimSpace(base))]
+	return name, ok
+}
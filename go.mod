@@ -0,0 +1,5 @@
+module github.com/madcowfred/go-index-html
+
+go 1.22
+
+require github.com/andybalholm/brotli v1.2.0
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Version of the JSON listing format; bump it on incompatible changes:
+const jsonListingVersion = 1
+
+type jsonEntry struct {
+	Name       string `json:"name"`
+	Href       string `json:"href"`                  // percent-encoded
+	Type       string `json:"type"`                  // "file", "directory", "symlink", "other" or "unknown"
+	Size       *int64 `json:"size,omitempty"`        // bytes; only for regular files
+	Modified   string `json:"modified,omitempty"`    // RFC 3339, UTC
+	MimeType   string `json:"mime_type,omitempty"`   // guessed from the extension
+	LinkTarget string `json:"link_target,omitempty"` // with -show-symlinks
+}
+
+type jsonListing struct {
+	Version    int         `json:"version"`
+	Path       string      `json:"path"`
+	Sort       string      `json:"sort"`
+	Modified   string      `json:"modified"`    // the directory's own, RFC 3339, UTC
+	EntryCount int         `json:"entry_count"` // including any left out by -max-entries
+	DirCount   int         `json:"dir_count"`
+	FileCount  int         `json:"file_count"`
+	TotalSize  int64       `json:"total_size"` // bytes, of regular files
+	Entries    []jsonEntry `json:"entries"`
+}
+
+// Check if the client asked for JSON rather than HTML:
+func acceptsJSON(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// Render a directory index as a JSON object:
+func writeJSON(buf *bytes.Buffer, page *indexPage) {
+	listing := jsonListing{
+		Version:    jsonListingVersion,
+		Path:       page.pathLink,
+		Sort:       page.sortString(),
+		Modified:   page.dirInfo.ModTime().UTC().Format(time.RFC3339),
+		EntryCount: page.summary.entries,
+		DirCount:   page.summary.dirs,
+		FileCount:  page.summary.files,
+		TotalSize:  page.summary.totalSize,
+		Entries:    make([]jsonEntry, 0, len(page.entries)),
+	}
+
+	for _, e := range page.entries {
+		je := jsonEntry{Name: e.name, Href: e.href, Type: e.kind(), LinkTarget: e.linkTarget}
+		if e.err == nil {
+			je.Modified = e.fi.ModTime().UTC().Format(time.RFC3339)
+			switch je.Type {
+			case "directory":
+				je.Href += "/"
+			case "file":
+				size := e.fi.Size()
+				je.Size = &size
+				je.MimeType = mime.TypeByExtension(path.Ext(e.fi.Name()))
+			}
+		}
+		je.Href = escapePath(je.Href)
+		listing.Entries = append(listing.Entries, je)
+	}
+
+	buf.WriteString(marshal(listing))
+}
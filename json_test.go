@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The documented JSON listing shape; changing it is an incompatible change that needs a new jsonListingVersion:
+const goldenJSONListing = `{"version":1,"path":"/","sort":"name-asc","modified":"2020-01-02T03:04:05Z",` +
+	`"entry_count":3,"dir_count":1,"file_count":2,"total_size":21,"entries":[` +
+	`{"name":"sub","href":"/sub/","type":"directory","modified":"2020-01-02T03:04:05Z"},` +
+	`{"name":"data.json","href":"/data.json","type":"file","size":9,"modified":"2020-01-02T03:04:05Z","mime_type":"application/json"},` +
+	`{"name":"my file.json","href":"/my%20file.json","type":"file","size":12,"modified":"2020-01-02T03:04:05Z","mime_type":"application/json"}]}`
+
+func TestJSONListingShape(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "sub/", "data.json", "my file.json")
+
+	// Fix the modification times, the directory's last since creating entries changes it:
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, name := range []string{"sub", "data.json", "my file.json", "."} {
+		if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newTestServer(t, dir)
+	rsp := serveRequest(s, "GET", "/?format=json", nil)
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rsp.Code, http.StatusOK)
+	}
+	if ct := rsp.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+	if got := rsp.Body.String(); got != goldenJSONListing {
+		t.Errorf("got:\n%s\nwant:\n%s", got, goldenJSONListing)
+	}
+
+	// The Accept header selects the same listing:
+	rsp = serveRequest(s, "GET", "/", http.Header{"Accept": {"application/json"}})
+	if got := rsp.Body.String(); got != goldenJSONListing {
+		t.Errorf("Accept: application/json: got:\n%s\nwant:\n%s", got, goldenJSONListing)
+	}
+}
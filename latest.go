@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Redirect to the newest (or oldest) file in a directory matching ?match=, for ?latest=1 or ?pick=:
+func (s *Server) serveLatest(rsp http.ResponseWriter, req *http.Request, u *url.URL, m *mount, localPath, relPath string) {
+	pattern := u.Query().Get("match")
+	if pattern == "" {
+		pattern = "*"
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		s.doError(req, rsp, fmt.Sprintf("Invalid match '%s': %s", pattern, err), http.StatusBadRequest)
+		return
+	}
+
+	dir := sortDescending
+	switch pick := u.Query().Get("pick"); pick {
+	case "", "newest":
+	case "oldest":
+		dir = sortAscending
+	default:
+		s.doError(req, rsp, fmt.Sprintf("Invalid pick '%s': expected newest or oldest", pick), http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		s.doFileError(req, rsp, err)
+		return
+	}
+	fis, err := s.readDir(req.Context(), f)
+	f.Close()
+	if err != nil {
+		s.doReadDirError(req, rsp, err)
+		return
+	}
+
+	// Only consider plain, listed files; symlinks aren't followed:
+	var files Entries
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			files = append(files, fi)
+		}
+	}
+	if len(files) == 0 {
+		s.doError(req, rsp, fmt.Sprintf("No file matches '%s'", pattern), http.StatusNotFound)
+		return
+	}
+	sort.Sort(ByDate{files, dir, true})
+
+	// The answer changes whenever a new file arrives:
+	rsp.Header().Set("Cache-Control", "no-cache")
+	doRedirect(req, rsp, escapePath(path.Join(m.proxyRoot, relPath, files[0].Name())), http.StatusFound)
+}
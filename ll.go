@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Render a directory index as plain text resembling `ls -l`, one entry per line:
+func (s *Server) writeLongListing(buf *bytes.Buffer, page *indexPage) {
+	// Right-align sizes to the widest one:
+	sizes := make([]string, len(page.entries))
+	width := 1
+	for i, e := range page.entries {
+		sizes[i] = "?"
+		if e.err == nil {
+			sizes[i] = strconv.FormatInt(e.fi.Size(), 10)
+		}
+		if len(sizes[i]) > width {
+			width = len(sizes[i])
+		}
+	}
+
+	for i, e := range page.entries {
+		mode, modTime := strings.Repeat("?", 10), "?"
+		if e.err == nil {
+			mode = modeString(e.fi.Mode())
+			modTime = e.fi.ModTime().In(s.timeLocation).Format(s.timeFormat)
+		}
+
+		name := e.name
+		if e.linkTarget != "" {
+			name += " -> " + e.linkTarget
+		}
+		fmt.Fprintf(buf, "%s %*s %s %s\n", mode, width, sizes[i], modTime, name)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How much to log, from failures only up to per-request diagnostics:
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelInfo
+	levelDebug
+)
+
+var logLevelNames = map[string]logLevel{
+	"error": levelError,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+func (l logLevel) String() string {
+	for name, level := range logLevelNames {
+		if level == l {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// A single structured log line, with request context where applicable:
+type logEntry struct {
+	Time      string  `json:"time"`
+	Level     string  `json:"level"`
+	Msg       string  `json:"msg"`
+	Method    string  `json:"method,omitempty"`
+	Path      string  `json:"path,omitempty"`
+	Status    int     `json:"status,omitempty"`
+	Remote    string  `json:"remote,omitempty"`
+	User      string  `json:"user,omitempty"`
+	Bytes     int64   `json:"bytes,omitempty"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+	Duration  float64 `json:"duration_ms,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+// Writes log entries as JSON objects, one per line:
+type jsonLogWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (j *jsonLogWriter) writeEntry(e logEntry) {
+	if e.Time == "" {
+		e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.w.Write(append(b, '\n'))
+}
+
+// Wrap lines from the standard logger, e.g. startup messages, as info entries:
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	j.writeEntry(logEntry{Level: "info", Msg: strings.TrimSuffix(string(p), "\n")})
+	return len(p), nil
+}
+
+// Log a message about a request, as text or JSON per -log-format, if -log-level allows it:
+func (s *Server) logRequest(level logLevel, req *http.Request, status int, msg string) {
+	if level > s.logLevel {
+		return
+	}
+
+	if s.jsonLog != nil {
+		s.jsonLog.writeEntry(logEntry{
+			Level:     level.String(),
+			Msg:       msg,
+			Method:    req.Method,
+			Path:      req.URL.Path,
+			Status:    status,
+			RequestID: requestID(req),
+		})
+		return
+	}
+
+	prefix := req.Method + " " + req.URL.Path
+	if id := requestID(req); id != "" {
+		prefix += " [" + id + "]"
+	}
+	if status != 0 {
+		log.Printf("%s: %d %s", prefix, status, msg)
+	} else {
+		log.Printf("%s: %s", prefix, msg)
+	}
+}
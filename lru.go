@@ -0,0 +1,68 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A fixed-size, concurrency-safe least-recently-used cache.
+type lruCache struct {
+	lock     sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) Add(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key, value})
+
+	// Evict the least recently used entries:
+	for c.order.Len() > c.capacity {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*lruEntry).key)
+	}
+}
+
+// Remove all entries, e.g. when what they were rendered from has changed:
+func (c *lruCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"html"
+	"io"
 	"log"
 	"mime"
 	"net"
@@ -14,12 +18,99 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
-var proxyRoot, jailRoot, accelRedirect string
+// Server holds the mounts and options used to serve directory listings and files.
+type Server struct {
+	mounts       mountList
+	corsOrigin   string
+	healthPath   string
+	faviconPath  string
+	favicon      []byte
+	faviconName  string
+	debug        bool
+	relativeTime bool
+	showSymlinks bool
+	indexFile    string
+
+	// Hardening headers for listings; empty values aren't sent. The CSP in use is in liveConfig:
+	csp            string
+	referrerPolicy string
+	frameOptions   string
+
+	allowChecksum      bool
+	checksumCache      *lruCache // hex checksums by algorithm, path, modtime and size
+	showLinkIndicator  bool
+	allowAdmin         bool
+	allowSearch        bool
+	searchMaxDepth     int
+	searchMaxResults   int
+	searchTimeout      time.Duration
+	allowDu            bool
+	duMaxDepth         int
+	duMaxEntries       int
+	duCache            *lruCache // subtree sizes by local path
+	duCacheTTL         time.Duration
+	accelHeader        string
+	dirTimeout         time.Duration
+	listingCache       *lruCache
+	listingCacheTTL    time.Duration
+	showOwner          bool
+	showMode           bool
+	friendlyTypes      bool
+	clientSort         bool
+	theme              string
+	externalAssets     bool
+	assetsPrefix       string
+	thumbnails         bool
+	thumbnailDir       string
+	thumbnailSlots     chan struct{} // limits concurrent thumbnail generation
+	strict             bool
+	defaultSort        string
+	sortHints          sortHintList
+	trustProxy         bool
+	maxEntries         int
+	requestSlots       chan struct{} // one per request being served, with -max-concurrent
+	symlinkMode        string
+	showDotDirs        bool
+	precompressed      bool
+	compress           bool
+	maxBodySize        int64
+	dotAllow           map[string]bool
+	accelContentLength bool
+	defaultContentType string
+	timeFormat         string
+	timeLocation       *time.Location
+
+	// Settings re-read on SIGHUP; holds a *liveConfig:
+	liveConfig atomic.Value
+
+	// Where access log lines are written; nil disables access logging.
+	accessLog     io.Writer
+	accessLogLock sync.Mutex
+
+	// Set with -log-format json to write logs as JSON objects instead of text:
+	jsonLog  *jsonLogWriter
+	logLevel logLevel
+
+	// Header to read and echo request IDs in; empty disables request IDs:
+	requestIDHeader string
+}
+
+const defaultTimeFormat = "2006-01-02 15:04:05 -0700 MST"
+
+// Methods advertised in Allow headers:
+const allowedMethods = "GET, HEAD, OPTIONS, PROPFIND"
 
 func startsWith(s, start string) bool {
 	if len(s) < len(start) {
@@ -28,6 +119,12 @@ func startsWith(s, start string) bool {
 	return s[0:len(start)] == start
 }
 
+// Check if p equals prefix or lies beneath it, so "/srv/data" doesn't match "/srv/database":
+func pathHasPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return p == prefix || startsWith(p, prefix+"/")
+}
+
 func removeIfStartsWith(s, start string) string {
 	if !startsWith(s, start) {
 		return s
@@ -35,8 +132,26 @@ func removeIfStartsWith(s, start string) string {
 	return s[len(start):]
 }
 
-func translateForProxy(s string) string {
-	return path.Join(proxyRoot, removeIfStartsWith(s, jailRoot))
+// Check if a decoded URL path contains ".." segments or null bytes:
+func isUnsafePath(p string) bool {
+	if strings.IndexByte(p, 0) >= 0 {
+		return true
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// Percent-encode each segment of a path for use in a URL, e.g. "/my file #2.txt" -> "/my%20file%20%232.txt":
+func escapePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segs, "/")
 }
 
 // For directory entry sorting:
@@ -46,12 +161,29 @@ type Entries []os.FileInfo
 func (s Entries) Len() int      { return len(s) }
 func (s Entries) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
+// Order directories before files unless mixed; ok is false if that doesn't decide it:
+func (s Entries) dirsFirst(i, j int, mixed bool) (less, ok bool) {
+	if mixed || s[i].IsDir() == s[j].IsDir() {
+		return false, false
+	}
+	return s[i].IsDir(), true
+}
+
+// Size used for sorting; directories count as empty so they interleave sensibly with files:
+func sortSize(fi os.FileInfo) int64 {
+	if fi.IsDir() {
+		return 0
+	}
+	return fi.Size()
+}
+
 type sortBy int
 
 const (
 	sortByName sortBy = iota
 	sortByDate
 	sortBySize
+	sortByManual // the order listed in .index-sort; only valid there
 )
 
 type sortDirection int
@@ -61,18 +193,47 @@ const (
 	sortDescending
 )
 
+// Names of the sort modes as used in ?sort= and .index-sort values:
+var sortByNames = map[sortBy]string{
+	sortByName: "name",
+	sortByDate: "date",
+	sortBySize: "size",
+}
+
+// Parse a sort string such as "date-desc":
+func parseSort(sortString string) (sortBy, sortDirection, bool) {
+	parts := strings.SplitN(sortString, "-", 2)
+	if len(parts) != 2 {
+		return sortByName, sortAscending, false
+	}
+
+	var dir sortDirection
+	switch parts[1] {
+	case "asc":
+		dir = sortAscending
+	case "desc":
+		dir = sortDescending
+	default:
+		return sortByName, sortAscending, false
+	}
+	for by, name := range sortByNames {
+		if name == parts[0] {
+			return by, dir, true
+		}
+	}
+	return sortByName, sortAscending, false
+}
+
 // Sort by name:
 type ByName struct {
 	Entries
-	dir sortDirection
+	dir   sortDirection
+	mixed bool
 }
 
 func (s ByName) Less(i, j int) bool {
-	if s.Entries[i].IsDir() && !s.Entries[j].IsDir() {
-		return true
-	}
-	if !s.Entries[i].IsDir() && s.Entries[j].IsDir() {
-		return false
+	if less, ok := s.Entries.dirsFirst(i, j, s.mixed); ok {
+		return less
 	}
 
 	if s.dir == sortAscending {
@@ -85,15 +246,13 @@ func (s ByName) Less(i, j int) bool {
 // Sort by last modified time:
 type ByDate struct {
 	Entries
-	dir sortDirection
+	dir   sortDirection
+	mixed bool
 }
 
 func (s ByDate) Less(i, j int) bool {
-	if s.Entries[i].IsDir() && !s.Entries[j].IsDir() {
-		return true
-	}
-	if !s.Entries[i].IsDir() && s.Entries[j].IsDir() {
-		return false
+	if less, ok := s.Entries.dirsFirst(i, j, s.mixed); ok {
+		return less
 	}
 
 	if s.dir == sortAscending {
@@ -106,54 +265,180 @@ func (s ByDate) Less(i, j int) bool {
 // Sort by size:
 type BySize struct {
 	Entries
-	dir sortDirection
+	dir   sortDirection
+	mixed bool
 }
 
 func (s BySize) Less(i, j int) bool {
-	if s.Entries[i].IsDir() && !s.Entries[j].IsDir() {
-		return true
-	}
-	if !s.Entries[i].IsDir() && s.Entries[j].IsDir() {
-		return false
+	if less, ok := s.Entries.dirsFirst(i, j, s.mixed); ok {
+		return less
 	}
 
 	if s.dir == sortAscending {
-		return s.Entries[i].Size() < s.Entries[j].Size()
+		return sortSize(s.Entries[i]) < sortSize(s.Entries[j])
 	} else {
-		return s.Entries[i].Size() > s.Entries[j].Size()
+		return sortSize(s.Entries[i]) > sortSize(s.Entries[j])
+	}
+}
+
+// Sort in the order listed by a "manual" .index-sort file, then any unlisted entries by name:
+type ByManual struct {
+	Entries
+	order map[string]int
+	mixed bool
+}
+
+func (s ByManual) Less(i, j int) bool {
+	oi, listedI := s.order[s.Entries[i].Name()]
+	oj, listedJ := s.order[s.Entries[j].Name()]
+	if listedI && listedJ {
+		return oi < oj
+	}
+	if listedI != listedJ {
+		return listedI
 	}
+	return ByName{s.Entries, sortAscending, s.mixed}.Less(i, j)
 }
 
-func followSymlink(localPath string, dfi os.FileInfo) os.FileInfo {
+func followSymlink(m *mount, localPath string, dfi os.FileInfo) (os.FileInfo, error) {
 	// Check symlink:
 	if (dfi.Mode() & os.ModeSymlink) != 0 {
 
 		dfiPath := path.Join(localPath, dfi.Name())
-		if targetPath, err := os.Readlink(dfiPath); err == nil {
-			// Find the absolute path of the symlink's target:
-			if !path.IsAbs(targetPath) {
-				targetPath = path.Join(localPath, targetPath)
-			}
-			if tdfi, err := os.Stat(targetPath); err == nil {
-				// Change to the target so we get its properties instead of the symlink's:
-				return tdfi
-			}
+		// Resolve the full chain of links so an in-jail link to an outside link is caught too:
+		targetPath, err := filepath.EvalSymlinks(dfiPath)
+		if err != nil {
+			return dfi, err
+		}
+		// Don't leak the properties of targets outside the jail:
+		if !m.isInsideJail(targetPath) {
+			return dfi, nil
 		}
+		tdfi, err := os.Stat(targetPath)
+		if err != nil {
+			return dfi, err
+		}
+		// Change to the target so we get its properties instead of the symlink's:
+		return tdfi, nil
+	}
+
+	return dfi, nil
+}
+
+// Format a count with thousands separators, e.g. "12,345":
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// Describe how long ago `t` was relative to `now`, e.g. "3 days ago":
+func timeAgo(t, now time.Time) string {
+	d := now.Sub(t)
+	if d < 0 {
+		return "in the future"
+	}
+
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
 	}
 
-	return dfi
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int64(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int64(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		return plural(int64(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return plural(int64(d/(30*24*time.Hour)), "month")
+	default:
+		return plural(int64(d/(365*24*time.Hour)), "year")
+	}
 }
 
 // Logging+action functions
-func doError(req *http.Request, rsp http.ResponseWriter, msg string, code int) {
+// Log the detailed error message and reply with a generic one (unless debugging):
+func (s *Server) doError(req *http.Request, rsp http.ResponseWriter, msg string, code int) {
+	s.logRequest(levelError, req, code, msg)
+	if !s.debug {
+		msg = http.StatusText(code)
+	}
+
+	// Render the styled error page if one is configured:
+	if t := s.live().errorTemplate; t != nil {
+		buf := &bytes.Buffer{}
+		err := t.Execute(buf, errorPage{StatusCode: code, StatusText: http.StatusText(code), Message: msg})
+		if err == nil {
+			rsp.Header().Del("Content-Length")
+			rsp.Header().Set("Content-Type", "text/html; charset=utf-8")
+			rsp.Header().Set("X-Content-Type-Options", "nosniff")
+			rsp.WriteHeader(code)
+			buf.WriteTo(rsp)
+			return
+		}
+		s.logRequest(levelError, req, 0, "rendering error template: "+err.Error())
+	}
+
 	http.Error(rsp, msg, code)
 }
 
+// Fields available to the -error-template:
+type errorPage struct {
+	StatusCode int    // e.g. 404
+	StatusText string // e.g. "Not Found"
+	Message    string // the error detail with -debug, otherwise the same as StatusText
+}
+
+// Map a filesystem error to an HTTP status:
+func (s *Server) doFileError(req *http.Request, rsp http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if os.IsPermission(err) {
+		code = http.StatusForbidden
+	} else if os.IsNotExist(err) {
+		code = http.StatusNotFound
+	}
+
+	s.doError(req, rsp, err.Error(), code)
+}
+
 func doRedirect(req *http.Request, rsp http.ResponseWriter, url string, code int) {
 	http.Redirect(rsp, req, url, code)
 }
 
-func doOK(req *http.Request, msg string, code int) {
+func (s *Server) doOK(req *http.Request, msg string, code int) {
+	s.logRequest(levelDebug, req, code, msg)
+}
+
+// Add CORS headers to the response if a CORS origin is configured:
+func (s *Server) addCorsHeaders(rsp http.ResponseWriter, req *http.Request) {
+	if s.corsOrigin == "" {
+		return
+	}
+
+	h := rsp.Header()
+	h.Set("Access-Control-Allow-Origin", s.corsOrigin)
+	if s.corsOrigin != "*" {
+		h.Add("Vary", "Origin")
+	}
+
+	// Answer preflight requests:
+	if req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != "" {
+		h.Set("Access-Control-Allow-Methods", s.allowedMethods())
+		if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			h.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		h.Set("Access-Control-Max-Age", "86400")
+	} else {
+		h.Set("Access-Control-Expose-Headers", "Content-Length, Last-Modified, ETag")
+	}
 }
 
 // Marshal an object to JSON or panic.
@@ -165,316 +450,1791 @@ func marshal(v interface{}) string {
 	return string(b)
 }
 
-func generateIndexHtml(rsp http.ResponseWriter, req *http.Request, u *url.URL) {
+// A directory entry prepared for rendering:
+type indexEntry struct {
+	name       string      // entry name
+	href       string      // proxy path of the entry
+	fi         os.FileInfo // the entry's info, or its target's for followed symlinks
+	linkTarget string      // symlink target when symlinks are shown rather than followed
+	followed   string      // symlink target when the entry is a symlink followed within the jail
+	err        error       // set when the entry's info couldn't be resolved
+}
+
+// Classify an entry as "file", "directory", "symlink" (unresolved), "other" or "unknown" (unreadable):
+func (e *indexEntry) kind() string {
+	switch {
+	case e.err != nil:
+		return "unknown"
+	case (e.fi.Mode() & os.ModeSymlink) != 0:
+		return "symlink"
+	case e.fi.IsDir():
+		return "directory"
+	case e.fi.Mode().IsRegular():
+		return "file"
+	}
+	return "other"
+}
+
+// Everything needed to render a directory index in any format:
+type indexPage struct {
+	pathLink     string
+	dirInfo      os.FileInfo
+	entries      []indexEntry
+	showParent   bool
+	showRelative bool
+	sortBy       sortBy
+	sortDir      sortDirection
+	mixed        bool
+	omitted      int // entries left out by -max-entries
+	summary      indexSummary
+	search       string // the ?search= term, if this lists search results
+	query        url.Values
+	stopped      bool // whether the search stopped before finding every match
+}
+
+// Totals over all listed entries, including any left out by -max-entries:
+type indexSummary struct {
+	entries   int
+	dirs      int
+	files     int
+	totalSize int64 // of regular files
+}
+
+func summarizeEntries(entries []indexEntry) indexSummary {
+	sum := indexSummary{entries: len(entries)}
+	for _, e := range entries {
+		switch e.kind() {
+		case "directory":
+			sum.dirs++
+		case "file":
+			sum.files++
+			sum.totalSize += e.fi.Size()
+		}
+	}
+	return sum
+}
+
+// Read all entries of an open directory, giving up after the configured timeout or when ctx is done:
+func (s *Server) readDir(ctx context.Context, f *os.File) ([]os.FileInfo, error) {
+	if s.dirTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.dirTimeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return f.Readdir(0)
+	}
+
+	type result struct {
+		fis []os.FileInfo
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// NOTE: a hung read on a stalled network mount can't be interrupted; this goroutine finishes whenever it does.
+		fis, err := f.Readdir(0)
+		done <- result{fis, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.fis, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Reply to a failed directory read:
+func (s *Server) doReadDirError(req *http.Request, rsp http.ResponseWriter, err error) {
+	if err == context.DeadlineExceeded {
+		s.doError(req, rsp, "Timed out reading directory", http.StatusGatewayTimeout)
+		return
+	}
+	if err == context.Canceled {
+		// The client went away; there's nobody to reply to:
+		return
+	}
+
+	s.doFileError(req, rsp, err)
+}
+
+// Name of the per-directory sort mode file:
+const indexSortFile = ".index-sort"
+
+// Names of control files, which are never listed:
+var controlFiles = map[string]bool{
+	indexSortFile: true,
+	indexAuthFile: true,
+}
+
+// Check if a dot-prefixed entry should be listed anyway, e.g. ".well-known":
+func (s *Server) showDotEntry(dfi os.FileInfo) bool {
+	if controlFiles[dfi.Name()] {
+		return false
+	}
+	return s.dotAllow[dfi.Name()] || (s.showDotDirs && dfi.IsDir())
+}
+
+// Filter and resolve directory entries for display:
+func (s *Server) indexEntries(req *http.Request, m *mount, localPath string, fis []os.FileInfo) ([]indexEntry, error) {
+	ctx := req.Context()
+	entries := make([]indexEntry, 0, len(fis))
+	for i, dfi := range fis {
+		// Stop early if the client has gone away:
+		if i%256 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// Skip hidden files (and guard against empty names):
+		name := dfi.Name()
+		if name == "" || (strings.HasPrefix(name, ".") && !s.showDotEntry(dfi)) {
+			continue
+		}
+
+		dfiPath := path.Join(localPath, name)
+		e := indexEntry{name: name, href: m.translateForProxy(dfiPath)}
+		if s.showSymlinks && (dfi.Mode()&os.ModeSymlink) != 0 {
+			// Show the link itself rather than transparently resolving it:
+			if target, err := os.Readlink(dfiPath); err == nil {
+				e.linkTarget = m.displayLinkTarget(dfiPath, target)
+			}
+		} else {
+			wasLink := (dfi.Mode() & os.ModeSymlink) != 0
+			if dfi, e.err = followSymlink(m, localPath, dfi); e.err != nil {
+				s.logRequest(levelDebug, req, 0, fmt.Sprintf("%s: %s", dfiPath, e.err))
+			} else if wasLink && (dfi.Mode()&os.ModeSymlink) == 0 {
+				// Remember the link for -show-link-indicator, now that the target is known to be in the jail:
+				if target, err := os.Readlink(dfiPath); err == nil {
+					e.followed = m.displayLinkTarget(dfiPath, target)
+				}
+			}
+		}
+		e.fi = dfi
+
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *Server) generateIndex(rsp http.ResponseWriter, req *http.Request, u *url.URL, m *mount) {
+	start := time.Now()
+
 	// Build index.html
-	relPath := removeIfStartsWith(u.Path, proxyRoot)
+	relPath := removeIfStartsWith(u.Path, m.proxyRoot)
 
-	localPath := path.Join(jailRoot, relPath)
-	pathLink := path.Join(proxyRoot, relPath)
+	localPath := path.Join(m.jailRoot, relPath)
+	pathLink := path.Join(m.proxyRoot, relPath)
+
+	// Determine the output format, falling back to the Accept header:
+	format := u.Query().Get("format")
+	if format == "" && acceptsAutoindex(req) {
+		format = "autoindex"
+	} else if format == "" && acceptsJSON(req) {
+		format = "json"
+	}
 
-	baseDir := path.Dir(localPath)
-	if localPath[len(localPath)-1] == '/' {
-		baseDir = path.Dir(localPath[0 : len(localPath)-1])
+	// Determine whether directories are grouped before files:
+	mixed := false
+	switch group := u.Query().Get("group"); group {
+	case "", "dirs":
+	case "none":
+		mixed = true
+	default:
+		s.doError(req, rsp, fmt.Sprintf("Invalid group '%s': expected dirs or none", group), http.StatusBadRequest)
+		return
 	}
-	if baseDir == "" {
-		baseDir = "/"
+
+	// Determine which kinds of entries to list:
+	only := u.Query().Get("only")
+	if only != "" && only != "dirs" && only != "files" {
+		s.doError(req, rsp, fmt.Sprintf("Invalid only '%s': expected dirs or files", only), http.StatusBadRequest)
+		return
 	}
 
-	// Determine what mode to sort by...
-	sortString := ""
+	// Determine what mode to sort by, starting from the server-wide default...
+	sortString := s.defaultSort
 
-	// Check the .index-sort file:
-	if sf, err := os.Open(path.Join(localPath, ".index-sort")); err == nil {
+	// Then any -sort-hint matching the directory's name:
+	if hinted, ok := s.sortHints.match(path.Base(localPath)); ok {
+		sortString = hinted
+	}
+
+	// Check the .index-sort file, which may instead list names in a manual order after a "manual" line:
+	var manualOrder map[string]int
+	if sf, err := os.Open(path.Join(localPath, indexSortFile)); err == nil {
 		defer sf.Close()
 		scanner := bufio.NewScanner(sf)
 		if scanner.Scan() {
-			sortString = scanner.Text()
+			if _, _, ok := parseSort(scanner.Text()); ok {
+				sortString = scanner.Text()
+			} else if strings.TrimSpace(scanner.Text()) == "manual" {
+				sortString = "manual"
+				manualOrder = map[string]int{}
+				for scanner.Scan() {
+					name := strings.TrimSpace(scanner.Text())
+					if _, dup := manualOrder[name]; name != "" && !dup {
+						manualOrder[name] = len(manualOrder)
+					}
+				}
+			}
 		}
 	}
 
-	// Use query-string 'sort' to override sorting:
+	// Feeds list the newest entries first unless asked otherwise:
+	if format == "rss" || format == "atom" {
+		sortString = "date-desc"
+	}
+
+	// Use query-string 'sort' to override sorting, ignoring unknown values unless strict:
 	sortStringQuery := u.Query().Get("sort")
 	if sortStringQuery != "" {
-		sortString = sortStringQuery
+		if _, _, ok := parseSort(sortStringQuery); ok || (sortStringQuery == "manual" && manualOrder != nil) {
+			sortString = sortStringQuery
+		} else if s.strict {
+			s.doError(req, rsp, fmt.Sprintf("Invalid sort '%s': expected name, date or size followed by -asc or -desc", sortStringQuery), http.StatusBadRequest)
+			return
+		}
 	}
 
-	// default Sort mode for headers
-	nameSort := "name-asc"
-	dateSort := "date-asc"
-	sizeSort := "size-asc"
+	// Use query-string 'time' to override the modified time display:
+	showRelative := s.relativeTime
+	switch u.Query().Get("time") {
+	case "relative":
+		showRelative = true
+	case "absolute":
+		showRelative = false
+	}
 
 	// Determine the sorting mode:
 	sortBy, sortDir := sortByName, sortAscending
-	switch sortString {
-	case "size-desc":
-		sortBy, sortDir = sortBySize, sortDescending
-	case "size-asc":
-		sortBy, sortDir = sortBySize, sortAscending
-		sizeSort = "size-desc"
-	case "date-desc":
-		sortBy, sortDir = sortByDate, sortDescending
-	case "date-asc":
-		sortBy, sortDir = sortByDate, sortAscending
-		dateSort = "date-desc"
-	case "name-desc":
-		sortBy, sortDir = sortByName, sortDescending
-	case "name-asc":
-		sortBy, sortDir = sortByName, sortAscending
-		nameSort = "name-desc"
-	default:
+	if by, dir, ok := parseSort(sortString); ok {
+		sortBy, sortDir = by, dir
+	} else if sortString == "manual" && manualOrder != nil {
+		sortBy = sortByManual
+	}
+
+	// Don't bother if the client has already gone away:
+	ctx := req.Context()
+	if ctx.Err() != nil {
+		return
 	}
 
 	// Open the directory to read its contents:
 	f, err := os.Open(localPath)
 	if err != nil {
-		doError(req, rsp, err.Error(), http.StatusInternalServerError)
+		s.doFileError(req, rsp, err)
 		return
 	}
 	defer f.Close()
 
-	// Read the directory entries:
-	fis, err := f.Readdir(0)
+	// Stat the directory itself for the Last-Modified header:
+	fi, err := f.Stat()
+	if err != nil {
+		s.doFileError(req, rsp, err)
+		return
+	}
+
+	// Search the subtree instead of listing the directory if asked; the directory's modtime says nothing about
+	// the subtree, so results aren't cached:
+	search := ""
+	if s.allowSearch {
+		search = u.Query().Get("search")
+	}
+
+	// Serve a cached rendering if the directory hasn't changed since; mounts sharing a directory render it under
+	// different URLs, so the key is the URL path rather than the local one, and feeds contain absolute URLs, so
+	// it includes the scheme and host the client used (per -trust-proxy):
+	scheme, host := s.requestOrigin(req)
+	cacheKey := scheme + "://" + host + "|" + pathLink + "|" + format + "|" + sortString + "|" + u.Query().Encode()
+	if cached := s.cachedListing(cacheKey, fi.ModTime()); search == "" && cached != nil {
+		s.writeIndexResponse(rsp, req, cached.contentType, cached.body, fi.ModTime())
+		observeIndexPage(start)
+		return
+	}
+
+	// Read the directory entries, or the matches below it:
+	var fis []os.FileInfo
+	stopped := false
+	if search != "" {
+		fis, stopped, err = s.searchTree(ctx, localPath, search)
+	} else {
+		fis, err = s.readDir(ctx, f)
+	}
 	if err != nil {
-		doError(req, rsp, err.Error(), http.StatusInternalServerError)
+		s.doReadDirError(req, rsp, err)
 		return
 	}
 
 	// Sort the entries by the desired mode:
 	switch sortBy {
 	default:
-		sort.Sort(ByName{fis, sortDir})
+		sort.Sort(ByName{fis, sortDir, mixed})
 	case sortByName:
-		sort.Sort(ByName{fis, sortDir})
+		sort.Sort(ByName{fis, sortDir, mixed})
 	case sortByDate:
-		sort.Sort(ByDate{fis, sortDir})
+		sort.Sort(ByDate{fis, sortDir, mixed})
 	case sortBySize:
-		sort.Sort(BySize{fis, sortDir})
+		sort.Sort(BySize{fis, sortDir, mixed})
+	case sortByManual:
+		sort.Sort(ByManual{fis, manualOrder, mixed})
 	}
 
-	// TODO: check Accepts header to reply accordingly (i.e. add JSON support)
+	entries, err := s.indexEntries(req, m, localPath, fis)
+	if err != nil {
+		// The client went away; there's nobody to reply to:
+		return
+	}
 
-	pathHtml := html.EscapeString(pathLink)
+	// Filter the entries with ?only=, keeping the order they were sorted in:
+	if only != "" {
+		filtered := entries[:0]
+		for _, e := range entries {
+			if e.err == nil && e.fi.IsDir() == (only == "dirs") {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+	}
 
-	rsp.Header().Add("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(rsp, `<!DOCTYPE html>
-<html lang="en">
-  <head>
-    <title>%s</title>
-    <meta charset="utf-8">
-    <meta http-equiv="X-UA-Compatible" content="IE=edge">
-    <meta name="viewport" content="width=device-width, initial-scale=1">
-    <link rel="stylesheet" href=".static/bootstrap.min.css">
-    <style type="text/css">
-td, th { white-space: nowrap; padding: 4px 5px !important; }
-.modified { text-align: center; width: 16em; }
-.size { width: 7em; }
-th.size { text-align: center; }
-td.size { text-align: right; }
-.type { width: 15em; }
-th.type { text-align: center; }
-    </style>
-  </head>
-  <body>
-    <div class="container">
-      <div class="row">
-      	<div class="col-xs-12">
-        <h2>Index of %s</h2>
-        <table class="table table-striped table-condensed table-bordered">
-          <thead>
-            <tr>
-              <th class="name"><a href="?sort=%s">Name</a></th>
-              <th class="size"><a href="?sort=%s">Size</a></th>
-              <th class="modified"><a href="?sort=%s">Last Modified</a></th>
-              <th class="type">Type</th>
-            </tr>
-          </thead>
-          <tbody>
-`, pathHtml, pathHtml, nameSort, sizeSort, dateSort)
+	summary := summarizeEntries(entries)
 
-	// Add the Parent Directory link if we're above the jail root:
-	if startsWith(baseDir, jailRoot) {
-		fmt.Fprintf(rsp, `
-        <tr>
-          <td class="name"><a href="../">../</a></td>
-          <td class="size"></td>
-          <td class="modified"></td>
-          <td class="type">Directory</td>
-        </tr>`)
+	// Cap the number of entries rendered:
+	omitted := 0
+	if s.maxEntries > 0 && len(entries) > s.maxEntries {
+		omitted = len(entries) - s.maxEntries
+		entries = entries[:s.maxEntries]
 	}
 
-	for _, dfi := range fis {
-		name := dfi.Name()
-		if name[0] == '.' {
-			continue
-		}
-
-		dfiPath := path.Join(localPath, name)
-		dfi = followSymlink(localPath, dfi)
+	page := &indexPage{
+		pathLink:     pathLink,
+		dirInfo:      fi,
+		entries:      entries,
+		showParent:   pathLink != path.Clean(m.proxyRoot),
+		showRelative: showRelative,
+		sortBy:       sortBy,
+		sortDir:      sortDir,
+		mixed:        mixed,
+		omitted:      omitted,
+		summary:      summary,
+		search:       search,
+		stopped:      stopped,
+		query:        u.Query(),
+	}
 
-		href := translateForProxy(dfiPath)
-		mt := mime.TypeByExtension(path.Ext(dfi.Name()))
+	// Render into a buffer so we can report Content-Length (and skip the body for HEAD requests):
+	buf := &bytes.Buffer{}
 
-		sizeText := ""
-		if dfi.IsDir() {
-			sizeText = "-"
-			name += "/"
-			href += "/"
-		} else {
-			size := dfi.Size()
-			if size < 1024*1024 {
-				sizeText = fmt.Sprintf("%.02f KiB", float64(size)/1024.0)
-			} else if size < 1024*1024*1024 {
-				sizeText = fmt.Sprintf("%.02f MiB", float64(size)/(1024.0*1024.0))
-			} else {
-				sizeText = fmt.Sprintf("%.02f GiB", float64(size)/(1024.0*1024.0*1024.0))
-			}
+	contentType := "text/html; charset=utf-8"
+	switch format {
+	case "rss", "atom":
+		if contentType, err = s.writeFeed(buf, req, page, format); err != nil {
+			s.doError(req, rsp, err.Error(), http.StatusInternalServerError)
+			return
 		}
+	case "json":
+		contentType = "application/json; charset=utf-8"
+		writeJSON(buf, page)
+	case "autoindex":
+		contentType = autoindexMediaType + "; charset=utf-8"
+		s.writeAutoindex(buf, page)
+	case "ll":
+		contentType = "text/plain; charset=utf-8"
+		s.writeLongListing(buf, page)
+	default:
+		s.writeIndexHtml(buf, page)
+	}
 
-		fmt.Fprintf(rsp, `
-            <tr>
-              <td class="name"><a href="%s">%s</a></td>
-              <td class="size">%s</td>
-              <td class="modified">%s</td>
-              <td class="type">%s</td>
-            </tr>`,
-			html.EscapeString(href),
-			html.EscapeString(name),
-			strings.Replace(html.EscapeString(sizeText), " ", "&nbsp;", -1),
-			html.EscapeString(dfi.ModTime().Format("2006-01-02 15:04:05 -0700 MST")),
-			html.EscapeString(mt),
-		)
+	if search == "" {
+		s.cacheListing(cacheKey, fi.ModTime(), contentType, buf.Bytes())
 	}
+	s.writeIndexResponse(rsp, req, contentType, buf.Bytes(), fi.ModTime())
 
-	fmt.Fprintf(rsp, `
-          </tbody>
-        </table>
-      </div>
-      </div>
-    </div>
-  </body>
-</html>`)
+	observeIndexPage(start)
 
-	doOK(req, localPath, http.StatusOK)
+	s.doOK(req, fmt.Sprintf("%s: sort %s, %d entries", localPath, page.sortString(), summary.entries), http.StatusOK)
 	return
 }
 
-func processProxiedRequest(rsp http.ResponseWriter, req *http.Request, u *url.URL) {
-	relPath := removeIfStartsWith(u.Path, proxyRoot)
-	localPath := path.Join(jailRoot, relPath)
+// The resolved sort mode, e.g. "date-desc":
+func (p *indexPage) sortString() string {
+	if p.sortBy == sortByManual {
+		return "manual"
+	}
+	if p.sortDir == sortDescending {
+		return sortByNames[p.sortBy] + "-desc"
+	}
+	return sortByNames[p.sortBy] + "-asc"
+}
 
-	// Check if the requested path is a symlink:
-	fi, err := os.Lstat(localPath)
-	if fi != nil && (fi.Mode()&os.ModeSymlink) != 0 {
-		localDir := path.Dir(localPath)
+// Query parameters that shape a listing, kept when it's re-sorted:
+var viewParams = []string{"only", "group", "time"}
 
-		// Check if file is a symlink and do 302 redirect:
-		linkDest, err := os.Readlink(localPath)
-		if err != nil {
-			doError(req, rsp, err.Error(), http.StatusBadRequest)
-			return
-		}
+// Link a column header to sorting by it: ascending at first, then toggling while it's the active column. The
+// result is a query string, escaped for use in an href, that keeps the active filters and search:
+func (p *indexPage) sortLink(by sortBy) string {
+	dir := "-asc"
+	if by == p.sortBy && p.sortDir == sortAscending {
+		dir = "-desc"
+	}
 
-		// NOTE(jsd): Problem here for links outside the jail folder.
-		if path.IsAbs(linkDest) && !startsWith(linkDest, jailRoot) {
-			doError(req, rsp, "Symlink points outside of jail", http.StatusBadRequest)
-			return
+	q := url.Values{"sort": {sortByNames[by] + dir}}
+	for _, name := range viewParams {
+		if v := p.query.Get(name); v != "" {
+			q.Set(name, v)
 		}
+	}
+	if p.search != "" {
+		q.Set("search", p.search)
+	}
+	return html.EscapeString(q.Encode())
+}
 
-		linkDest = path.Join(localDir, linkDest)
-		tp := translateForProxy(linkDest)
+// The page heading, e.g. "Index of /files":
+func (p *indexPage) title() string {
+	if p.search != "" {
+		return fmt.Sprintf("Search for “%s” in %s", p.search, p.pathLink)
+	}
+	return "Index of " + p.pathLink
+}
 
-		doRedirect(req, rsp, tp, http.StatusFound)
-		return
+// Mark the active sort column with its direction:
+func (p *indexPage) sortArrow(by sortBy) string {
+	if by != p.sortBy {
+		return ""
+	}
+	if p.sortDir == sortAscending {
+		return ` <span class="sort-arrow">▲</span>`
 	}
+	return ` <span class="sort-arrow">▼</span>`
+}
 
-	// Regular stat
-	fi, err = os.Stat(localPath)
-	if err != nil {
-		doError(req, rsp, err.Error(), http.StatusNotFound)
-		return
+// Let client-side sorting know whether directories are grouped before files:
+func (p *indexPage) groupAttr() string {
+	if p.mixed {
+		return ` data-group="none"`
 	}
+	return ""
+}
 
-	// Serve the file if it is regular:
-	if fi.Mode().IsRegular() {
-		// Send file:
+// Tell assistive technology which column is sorted and how, since the arrow is purely visual:
+func (p *indexPage) ariaSort(by sortBy) string {
+	if by != p.sortBy {
+		return ""
+	}
+	if p.sortDir == sortAscending {
+		return ` aria-sort="ascending"`
+	}
+	return ` aria-sort="descending"`
+}
 
-		// NOTE(jsd): using `http.ServeFile` does not appear to handle range requests well. Lots of broken pipe errors
-		// that lead to a poor client experience. X-Accel-Redirect back to nginx is much better.
+// Write a rendered directory index, leaving out the body for HEAD requests and answering 304 Not Modified to
+// revalidations whose ETag still matches:
+func (s *Server) writeIndexResponse(rsp http.ResponseWriter, req *http.Request, contentType string, body []byte, modTime time.Time) {
+	s.addCorsHeaders(rsp, req)
+	s.addSecurityHeaders(rsp)
+	// The directory's modtime misses changes to its entries, so revalidate by the rendered content instead:
+	etag := listingETag(body)
+	rsp.Header().Set("ETag", etag)
+	rsp.Header().Set("Cache-Control", "no-cache")
+	rsp.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	// The format may be negotiated from the Accept header, and the encoding from Accept-Encoding:
+	rsp.Header().Add("Vary", "Accept, Accept-Encoding")
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		rsp.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-		if accelRedirect != "" {
-			// Use X-Accel-Redirect if the cmdline option was given:
-			redirPath := path.Join(accelRedirect, relPath)
-			rsp.Header().Add("X-Accel-Redirect", redirPath)
-			rsp.Header().Add("Content-Type", mime.TypeByExtension(path.Ext(localPath)))
-			rsp.WriteHeader(200)
+	body, encoding := s.compressListing(req, body)
+	if encoding != "" {
+		rsp.Header().Set("Content-Encoding", encoding)
+	}
+	rsp.Header().Add("Content-Type", contentType)
+	rsp.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rsp.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		rsp.Write(body)
+	}
+}
+
+// Format a file mode the way ls -l does; FileMode.String() uses "L" for symlinks and
+// prefixes setuid/setgid/sticky flags rather than folding them into the permission bits:
+func modeString(m os.FileMode) string {
+	b := []byte("----------")
+	switch {
+	case m&os.ModeDir != 0:
+		b[0] = 'd'
+	case m&os.ModeSymlink != 0:
+		b[0] = 'l'
+	case m&os.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case m&os.ModeSocket != 0:
+		b[0] = 's'
+	case m&os.ModeCharDevice != 0:
+		b[0] = 'c'
+	case m&os.ModeDevice != 0:
+		b[0] = 'b'
+	}
+
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if m&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+
+	special := func(i int, set bool, c byte) {
+		if !set {
+			return
+		}
+		if b[i] == 'x' {
+			b[i] = c
 		} else {
-			// Just serve the file directly from the filesystem:
-			http.ServeFile(rsp, req, localPath)
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	special(3, m&os.ModeSetuid != 0, 's')
+	special(6, m&os.ModeSetgid != 0, 's')
+	special(9, m&os.ModeSticky != 0, 't')
+	return string(b)
+}
+
+// Bootstrap stylesheet linked instead of the embedded CSS with -external-assets:
+const bootstrapCDN = "//netdna.bootstrapcdn.com/bootstrap/3.1.1/css/bootstrap.min.css"
+
+// Render the base stylesheet, linked from the assets prefix or the CDN, or inlined if assets aren't served:
+func (s *Server) baseStylesheet() string {
+	if s.externalAssets {
+		return fmt.Sprintf("    <link rel=\"stylesheet\" href=\"%s\">\n", bootstrapCDN)
+	}
+	if s.assetsPrefix != "" {
+		return fmt.Sprintf("    <link rel=\"stylesheet\" href=\"%s\">\n", html.EscapeString(s.assetsPrefix+"style.css"))
+	}
+	return fmt.Sprintf("    <style type=\"text/css\">\n%s    </style>\n", readAsset("style.css"))
+}
+
+// Render the client-side sorting script, linked from the assets prefix or inlined:
+func (s *Server) clientSortScript() string {
+	if s.assetsPrefix != "" {
+		return fmt.Sprintf("\n    <script src=\"%s\"></script>", html.EscapeString(s.assetsPrefix+"sort.js"))
+	}
+	return fmt.Sprintf("\n    <script>%s</script>", inlineSortScript())
+}
+
+// The body of the inlined client-side sorting script, as hashed for the Content-Security-Policy:
+func inlineSortScript() string {
+	return "\n" + readAsset("sort.js") + "    "
+}
+
+// Describe FIFOs, sockets and devices; empty for anything else:
+func specialFileType(m os.FileMode) string {
+	switch {
+	case m&os.ModeNamedPipe != 0:
+		return "FIFO"
+	case m&os.ModeSocket != 0:
+		return "Socket"
+	case m&os.ModeDevice != 0:
+		return "Device"
+	}
+	return ""
+}
+
+// Count the optional columns enabled in HTML listings:
+func (s *Server) optionalColumnCount() int {
+	n := 0
+	if s.showOwner && ownerSupported {
+		n++
+	}
+	if s.showMode {
+		n++
+	}
+	return n
+}
+
+// Render the header cells of the optional columns:
+func (s *Server) optionalHeaders() string {
+	h := ""
+	if s.showOwner && ownerSupported {
+		h += `
+              <th class="owner">Owner</th>`
+	}
+	if s.showMode {
+		h += `
+              <th class="mode">Permissions</th>`
+	}
+	return h
+}
+
+// Render the optional column cells for an entry; fi may be nil for rows without file info:
+func (s *Server) optionalCells(fi os.FileInfo) string {
+	c := ""
+	if s.showOwner && ownerSupported {
+		owner := ""
+		if fi != nil && (fi.Mode()&os.ModeSymlink) == 0 {
+			owner = fileOwner(fi)
+		}
+		c += fmt.Sprintf(`
+              <td class="owner">%s</td>`, html.EscapeString(owner))
+	}
+	if s.showMode {
+		mode := ""
+		if fi != nil {
+			mode = modeString(fi.Mode())
+		}
+		c += fmt.Sprintf(`
+              <td class="mode">%s</td>`, html.EscapeString(mode))
+	}
+	return c
+}
+
+// Render a directory index as an HTML page:
+func (s *Server) writeIndexHtml(buf *bytes.Buffer, page *indexPage) {
+	titleHtml := html.EscapeString(page.title())
+	live := s.live()
+
+	fmt.Fprintf(buf, `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <title>%s</title>
+    <meta charset="utf-8">
+    <meta http-equiv="X-UA-Compatible" content="IE=edge">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="color-scheme" content="%s">
+%s%s    <style type="text/css">
+td, th { white-space: nowrap; padding: 4px 5px !important; }
+.modified { text-align: center; width: 16em; }
+.size { width: 7em; }
+th.size { text-align: center; }
+td.size { text-align: right; white-space: nowrap; }
+.type { width: 15em; }
+th.type { text-align: center; }
+.empty { text-align: center; font-style: italic; }
+.truncated { text-align: center; font-style: italic; }
+.checksum { font-size: smaller; float: right; }
+.owner { width: 10em; }
+.mode { width: 8em; font-family: monospace; }
+.sort-arrow { font-size: smaller; }
+.symlink-badge { font-size: smaller; font-style: italic; opacity: 0.7; }
+.thumb { max-width: 64px; max-height: 64px; margin-right: 5px; vertical-align: middle; }
+%s    </style>
+%s  </head>
+  <body>
+%s    <div class="container">
+      <div class="row">
+      	<div class="col-xs-12">
+        <h2>%s</h2>
+        <table class="table table-striped table-condensed table-bordered"%s>
+          <thead>
+            <tr>
+              <th class="name"%s><a href="?%s">Name</a>%s</th>
+              <th class="size"%s><a href="?%s">Size</a>%s</th>
+              <th class="modified"%s><a href="?%s">Last Modified</a>%s</th>
+              <th class="type">Type</th>%s
+            </tr>
+          </thead>
+          <tbody>
+`, titleHtml, themeColorScheme(s.theme), s.faviconLink(), s.baseStylesheet(), themeCSS(s.theme), live.customCSS, live.headerHtml, titleHtml, page.groupAttr(),
+		page.ariaSort(sortByName), page.sortLink(sortByName), page.sortArrow(sortByName),
+		page.ariaSort(sortBySize), page.sortLink(sortBySize), page.sortArrow(sortBySize),
+		page.ariaSort(sortByDate), page.sortLink(sortByDate), page.sortArrow(sortByDate),
+		s.optionalHeaders())
+
+	// Add the Parent Directory link unless it would lead above the mount's proxy root:
+	if page.showParent {
+		fmt.Fprintf(buf, `
+        <tr>
+          <td class="name"><a href="../">../</a></td>
+          <td class="size"></td>
+          <td class="modified"></td>
+          <td class="type">Directory</td>%s
+        </tr>`, s.optionalCells(nil))
+	}
+
+	now := time.Now()
+	for _, e := range page.entries {
+		name, href, dfi := e.name, e.href, e.fi
+
+		if e.err != nil {
+			// Render placeholders rather than misleading stats:
+			fmt.Fprintf(buf, `
+            <tr>
+              <td class="name" data-name="%s"><a href="%s">%s</a></td>
+              <td class="size" data-size="-1">?</td>
+              <td class="modified" data-modified="0">?</td>
+              <td class="type" data-type="unknown">unknown</td>%s
+            </tr>`,
+				html.EscapeString(name),
+				html.EscapeString(escapePath(href)),
+				html.EscapeString(name),
+				s.optionalCells(nil),
+			)
+			continue
+		}
+
+		mt := mime.TypeByExtension(path.Ext(dfi.Name()))
+
+		sizeText := ""
+		sizeBytes := int64(-1)
+		if (dfi.Mode() & os.ModeSymlink) != 0 {
+			// Unresolved symlink; don't report the link's own properties:
+			sizeText = "-"
+			mt = "Symlink"
+			if e.linkTarget != "" {
+				mt = "Symlink → " + e.linkTarget
+			}
+		} else if dfi.IsDir() {
+			sizeText = "-"
+			name += "/"
+			href += "/"
+		} else if t := specialFileType(dfi.Mode()); t != "" {
+			// Sizes of FIFOs, sockets and devices are meaningless:
+			sizeText = "-"
+			mt = t
+		} else {
+			size := dfi.Size()
+			sizeBytes = size
+			if size < 1024*1024 {
+				sizeText = fmt.Sprintf("%.02f KiB", float64(size)/1024.0)
+			} else if size < 1024*1024*1024 {
+				sizeText = fmt.Sprintf("%.02f MiB", float64(size)/(1024.0*1024.0))
+			} else {
+				sizeText = fmt.Sprintf("%.02f GiB", float64(size)/(1024.0*1024.0*1024.0))
+			}
+		}
+
+		// Preview images if thumbnails are enabled:
+		thumbnail := ""
+		if s.thumbnails && dfi.Mode().IsRegular() && isThumbnailable(dfi.Name()) {
+			thumbnail = fmt.Sprintf(`<img class="thumb" src="%s?thumb=1&amp;w=%d" loading="lazy" alt=""> `, html.EscapeString(escapePath(href)), listingThumbnailWidth)
+		}
+
+		// Mark followed symlinks if asked, with the target in a tooltip:
+		linkBadge := ""
+		if s.showLinkIndicator && e.followed != "" {
+			linkBadge = fmt.Sprintf(` <span class="symlink-badge" title="%s">link</span>`, html.EscapeString("Symlink → "+e.followed))
+		}
+
+		// Link to the file's checksum if allowed:
+		checksumLink := ""
+		if s.allowChecksum && dfi.Mode().IsRegular() {
+			checksumLink = fmt.Sprintf(` <a class="checksum" href="%s?checksum=sha256">sha256</a>`, html.EscapeString(escapePath(href)))
+		}
+
+		// Name common types readably, keeping the MIME type in a tooltip:
+		typeTitle := ""
+		if s.friendlyTypes && dfi.Mode().IsRegular() {
+			if friendly, ok := friendlyType(mt); ok {
+				typeTitle = fmt.Sprintf(` title="%s"`, html.EscapeString(mt))
+				mt = friendly
+			}
+		}
+
+		modTimeText := dfi.ModTime().In(s.timeLocation).Format(s.timeFormat)
+		modTimeDisplay := modTimeText
+		if page.showRelative {
+			modTimeDisplay = timeAgo(dfi.ModTime(), now)
+		}
+
+		fmt.Fprintf(buf, `
+            <tr>
+              <td class="name" data-name="%s">%s<a href="%s">%s</a>%s%s</td>
+              <td class="size" data-size="%d">%s</td>
+              <td class="modified" data-modified="%d" title="%s">%s</td>
+              <td class="type" data-type="%s"%s>%s</td>%s
+            </tr>`,
+			html.EscapeString(e.name),
+			thumbnail,
+			html.EscapeString(escapePath(href)),
+			html.EscapeString(name),
+			linkBadge,
+			checksumLink,
+			sizeBytes,
+			html.EscapeString(sizeText),
+			dfi.ModTime().Unix(),
+			html.EscapeString(modTimeText),
+			html.EscapeString(modTimeDisplay),
+			e.kind(),
+			typeTitle,
+			html.EscapeString(mt),
+			s.optionalCells(dfi),
+		)
+	}
+
+	// Note any entries left out by -max-entries:
+	if page.omitted > 0 {
+		fmt.Fprintf(buf, `
+            <tr>
+              <td class="truncated" colspan="%d">… %s more entries not shown</td>
+            </tr>`, 4+s.optionalColumnCount(), formatCount(page.omitted))
+	}
+
+	// Note a search that gave up early:
+	if page.stopped {
+		fmt.Fprintf(buf, `
+            <tr>
+              <td class="truncated" colspan="%d">… the search stopped early; there may be more matches</td>
+            </tr>`, 4+s.optionalColumnCount())
+	}
+
+	// Say so rather than rendering an empty table:
+	if len(page.entries) == 0 && page.omitted == 0 && !page.stopped {
+		empty := "This directory is empty"
+		if page.search != "" {
+			empty = "Nothing matches"
 		}
+		fmt.Fprintf(buf, `
+            <tr>
+              <td class="empty" colspan="%d">%s</td>
+            </tr>`, 4+s.optionalColumnCount(), empty)
+	}
+
+	fmt.Fprintf(buf, `
+          </tbody>
+        </table>
+      </div>
+      </div>
+    </div>`)
 
+	// Sort in the browser when headers are clicked; the ?sort= links still work without JS:
+	if s.clientSort {
+		buf.WriteString(s.clientSortScript())
+	}
+	if live.footerHtml != "" {
+		fmt.Fprintf(buf, "\n%s", strings.TrimSuffix(live.footerHtml, "\n"))
+	}
+
+	fmt.Fprintf(buf, `
+  </body>
+</html>`)
+}
+
+func (s *Server) processProxied(rsp http.ResponseWriter, req *http.Request, u *url.URL, m *mount) {
+	// Reject traversal attempts outright rather than relying on path.Join to clean them:
+	if isUnsafePath(u.Path) {
+		s.doError(req, rsp, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	relPath := removeIfStartsWith(u.Path, m.proxyRoot)
+	localPath := path.Join(m.jailRoot, relPath)
+
+	// Never serve access control files:
+	if path.Base(localPath) == indexAuthFile {
+		s.doError(req, rsp, "Access control file requested", http.StatusNotFound)
+		return
+	}
+
+	// Enforce per-directory access control:
+	if !s.checkIndexAuth(rsp, req, m, localPath) {
+		return
+	}
+
+	// Check if the requested path is a symlink:
+	fi, err := os.Lstat(localPath)
+	if fi != nil && (fi.Mode()&os.ModeSymlink) != 0 && s.symlinkMode == "follow" {
+		// Serve the target in place, resolving the whole chain so no hop can escape the jail:
+		targetPath, err := filepath.EvalSymlinks(localPath)
+		if err != nil {
+			s.doFileError(req, rsp, err)
+			return
+		}
+		if !m.isInsideJail(targetPath) {
+			s.doError(req, rsp, "Symlink points outside of jail", http.StatusBadRequest)
+			return
+		}
+		// Nor may a link expose a control file under another name:
+		if controlFiles[path.Base(targetPath)] {
+			s.doError(req, rsp, "Access control file requested", http.StatusNotFound)
+			return
+		}
+		// The target may be protected by its own .index-auth file:
+		if !s.checkIndexAuth(rsp, req, m, targetPath) {
+			return
+		}
+		localPath = targetPath
+		relPath = m.jailRelative(targetPath)
+	} else if fi != nil && (fi.Mode()&os.ModeSymlink) != 0 {
+		localDir := path.Dir(localPath)
+
+		// Check if file is a symlink and do 302 redirect:
+		linkDest, err := os.Readlink(localPath)
+		if err != nil {
+			s.doError(req, rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Resolve the link's target relative to its directory:
+		if !path.IsAbs(linkDest) {
+			linkDest = path.Join(localDir, linkDest)
+		}
+		linkDest = path.Clean(linkDest)
+		// Compare the real target against the (resolved) jail root, e.g. for absolute links naming the jail by a
+		// symlinked path; broken links are checked as written:
+		if resolved, err := filepath.EvalSymlinks(linkDest); err == nil {
+			linkDest = resolved
+		}
+
+		// Refuse links whose target escapes the jail, whether absolute or relative:
+		if !m.isInsideJail(linkDest) {
+			s.doError(req, rsp, "Symlink points outside of jail", http.StatusBadRequest)
+			return
+		}
+
+		tp := m.translateForProxy(linkDest)
+		// Carry the query over so e.g. checksum links to symlinks still work:
+		if u.RawQuery != "" {
+			tp += "?" + u.RawQuery
+		}
+
+		doRedirect(req, rsp, tp, http.StatusFound)
+		return
+	}
+
+	// Symlinked directories along the path, e.g. "dirlink" in /dirlink/x, must lead into the jail too:
+	if resolved, err := filepath.EvalSymlinks(localPath); err == nil && resolved != localPath {
+		if !m.isInsideJail(resolved) {
+			s.doError(req, rsp, "Path resolves outside of jail", http.StatusBadRequest)
+			return
+		}
+		// The target may be protected by its own .index-auth file:
+		if !s.checkIndexAuth(rsp, req, m, resolved) {
+			return
+		}
+	}
+
+	s.logRequest(levelDebug, req, 0, "resolved to "+localPath)
+
+	// Regular stat
+	fi, err = os.Stat(localPath)
+	if err != nil {
+		s.doError(req, rsp, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Answer admin requests:
+	if req.Method == "POST" {
+		if u.Query().Get("set-sort") == "" {
+			s.doError(req, rsp, "Unknown admin request", http.StatusBadRequest)
+			return
+		}
+		s.serveSetSort(rsp, req, u, localPath, fi)
+		return
+	}
+
+	// Answer WebDAV directory enumeration:
+	if req.Method == "PROPFIND" {
+		s.servePropfind(rsp, req, u, m, localPath, fi)
+		return
+	}
+
+	// Serve the file if it is regular:
+	if fi.Mode().IsRegular() {
+		if algo := u.Query().Get("checksum"); algo != "" && s.allowChecksum {
+			s.serveChecksum(rsp, req, algo, localPath, fi)
+			return
+		}
+		if u.Query().Get("thumb") != "" && s.thumbnails {
+			s.serveThumbnail(rsp, req, u, localPath, fi)
+			return
+		}
+		s.serveFile(rsp, req, m, relPath, localPath)
 		return
 	}
 
-	// Generate an index.html for directories:
 	if fi.Mode().IsDir() {
-		generateIndexHtml(rsp, req, u)
+		// Redirect to the slash-terminated URL so relative links resolve against the directory:
+		if !strings.HasSuffix(u.Path, "/") {
+			target := u.EscapedPath() + "/"
+			if u.RawQuery != "" {
+				target += "?" + u.RawQuery
+			}
+			doRedirect(req, rsp, target, http.StatusMovedPermanently)
+			return
+		}
+
+		// Report disk usage if asked:
+		if format := u.Query().Get("format"); s.allowDu && (format == "du" || format == "du-json") {
+			s.serveDiskUsage(rsp, req, u, localPath, path.Join(m.proxyRoot, relPath), format)
+			return
+		}
+
+		// Redirect to the newest matching file if asked:
+		if q := u.Query(); q.Get("latest") != "" || q.Get("match") != "" || q.Get("pick") != "" {
+			s.serveLatest(rsp, req, u, m, localPath, relPath)
+			return
+		}
+
+		// Serve a real index file if one is configured and present (symlinks are not followed):
+		if s.indexFile != "" {
+			indexPath := path.Join(localPath, s.indexFile)
+			if ifi, err := os.Lstat(indexPath); err == nil && ifi.Mode().IsRegular() {
+				s.serveFile(rsp, req, m, path.Join(relPath, s.indexFile), indexPath)
+				return
+			}
+		}
+
+		// Generate an index.html for directories:
+		s.generateIndex(rsp, req, u, m)
 		return
 	}
+
+	// Refuse FIFOs, sockets and devices rather than hanging on or serving them:
+	s.doError(req, rsp, "Not a regular file or directory", http.StatusForbidden)
+}
+
+// Check if an offload header expects an absolute local path (Apache mod_xsendfile, lighttpd):
+func isSendfileHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case "x-sendfile", "x-lighttpd-send-file":
+		return true
+	}
+	return false
+}
+
+// Sends a regular file, relPath being relative to the mount and localPath its location on disk:
+func (s *Server) serveFile(rsp http.ResponseWriter, req *http.Request, m *mount, relPath, localPath string) {
+	metrics.filesServed.Add(1)
+
+	// NOTE(jsd): using `http.ServeFile` does not appear to handle range requests well. Lots of broken pipe errors
+	// that lead to a poor client experience. X-Accel-Redirect back to nginx is much better.
+
+	// The content type comes from the original name even when serving a precompressed variant:
+	contentType := mime.TypeByExtension(path.Ext(localPath))
+	if contentType == "" {
+		contentType = s.defaultContentType
+	}
+	// Browsers must not second-guess the type, e.g. render an unknown file as HTML:
+	rsp.Header().Set("X-Content-Type-Options", "nosniff")
+	if s.precompressed {
+		rsp.Header().Add("Vary", "Accept-Encoding")
+		if variant, encoding := findPrecompressed(req, localPath); variant != "" {
+			rsp.Header().Set("Content-Encoding", encoding)
+			if contentType == "" {
+				// Don't let the compressed bytes be sniffed:
+				contentType = "application/octet-stream"
+			}
+			relPath += variant[len(localPath):]
+			localPath = variant
+		}
+	}
+
+	if sendfile := isSendfileHeader(s.accelHeader); sendfile || m.accelRedirect != "" {
+		// Use X-Accel-Redirect if the cmdline option was given:
+		redirPath := path.Join(m.accelRedirect, relPath)
+		if sendfile {
+			// X-Sendfile style headers take the absolute local path instead:
+			var err error
+			if redirPath, err = filepath.Abs(localPath); err != nil {
+				s.doError(req, rsp, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		rsp.Header().Add(s.accelHeader, redirPath)
+		if contentType != "" {
+			rsp.Header().Add("Content-Type", contentType)
+		}
+		if fi, err := os.Stat(localPath); err == nil {
+			// Let downstream caches revalidate even though the proxy serves the bytes:
+			rsp.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+			if s.accelContentLength {
+				// NOTE: no body follows, so the connection to the proxy is closed after these headers rather
+				// than reused; nginx serves the file itself and is not otherwise affected.
+				rsp.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+			}
+		}
+		rsp.WriteHeader(200)
+		return
+	}
+
+	// Serve the file directly from the filesystem; http.ServeContent on an explicitly opened file handles
+	// Range, If-Range and conditional requests without ServeFile's redirect and directory handling:
+	f, err := os.Open(localPath)
+	if err != nil {
+		s.doFileError(req, rsp, err)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		s.doFileError(req, rsp, err)
+		return
+	}
+
+	if contentType != "" {
+		rsp.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(rsp, req, fi.Name(), fi.ModTime(), f)
 }
 
 // Serves an index.html file for a directory or sends the requested file.
-func processRequest(rsp http.ResponseWriter, req *http.Request) {
+func (s *Server) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+	// Tag the request so its log lines can be correlated across proxies:
+	req = s.withRequestID(rsp, req)
+
+	// Record the final status and size so every request can be logged regardless of outcome:
+	rec := &statusRecorder{ResponseWriter: rsp}
+	defer func(start time.Time) {
+		observeRequest(rec)
+		s.logAccess(rec, req, start)
+	}(time.Now())
+	rsp = rec
+
+	// Turn a panic into a 500 for this request rather than an aborted connection:
+	defer func() {
+		if r := recover(); r != nil {
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			s.logRequest(levelError, req, 0, fmt.Sprintf("panic: %v\n%s", r, debug.Stack()))
+			if !rec.wroteHeader() {
+				s.doError(req, rsp, fmt.Sprint(r), http.StatusInternalServerError)
+			}
+		}
+	}()
+
 	// proxy sends us absolute path URLs
 	u, err := url.Parse(req.RequestURI)
 	if err != nil {
-		log.Fatal(err)
+		s.doError(req, rsp, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if startsWith(u.Path, proxyRoot) {
-		// URL is under the proxy path:
-		processProxiedRequest(rsp, req, u)
+	// This is a read-only server, apart from -allow-admin; refuse anything else and don't accept large bodies:
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PROPFIND":
+	case "POST":
+		if !s.allowAdmin {
+			rsp.Header().Set("Allow", s.allowedMethods())
+			s.doError(req, rsp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	default:
+		rsp.Header().Set("Allow", s.allowedMethods())
+		s.doError(req, rsp, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if req.Body != nil {
+		req.Body = http.MaxBytesReader(rsp, req.Body, s.maxBodySize)
+	}
+
+	// Answer liveness probes without touching the filesystem:
+	if s.healthPath != "" && u.Path == s.healthPath {
+		rsp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rsp.WriteHeader(http.StatusOK)
+		if req.Method != "HEAD" {
+			fmt.Fprintln(rsp, "OK")
+		}
+		return
+	}
+
+	// Shed load rather than let a burst of requests exhaust file descriptors and memory for everyone:
+	if s.requestSlots != nil {
+		select {
+		case s.requestSlots <- struct{}{}:
+			defer func() { <-s.requestSlots }()
+		default:
+			rsp.Header().Set("Retry-After", "1")
+			s.doError(req, rsp, "Too many concurrent requests", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	// Answer favicon requests before they reach the mounts and clutter the logs with 404s, unless there's no
+	// -favicon and a mount has its own:
+	if s.faviconPath != "" && u.Path == s.faviconPath && (s.favicon != nil || !s.mountHasFile(u.Path)) {
+		s.serveFavicon(rsp, req)
+		return
+	}
+
+	// Serve the embedded assets from their reserved prefix:
+	if s.assetsPrefix != "" && startsWith(u.Path, s.assetsPrefix) {
+		s.serveAsset(rsp, req, u.Path[len(s.assetsPrefix):])
+		return
+	}
+
+	// Answer capability probes without touching the filesystem:
+	if req.Method == "OPTIONS" {
+		s.addCorsHeaders(rsp, req)
+		rsp.Header().Set("Allow", s.allowedMethods())
+		rsp.Header().Set("DAV", "1")
+		rsp.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Require HTTP Basic authentication if configured:
+	if auth := s.live().auth; auth != nil && !s.checkAuth(rsp, req, auth, "index-html") {
+		return
+	}
+
+	if m := s.findMount(u.Path); m != nil {
+		// URL is under a mount's proxy path:
+		s.processProxied(rsp, req, u, m)
+		return
+	}
+
+	s.doError(req, rsp, "No mount matches the request path", http.StatusNotFound)
+}
+
+// Collects repeated or comma-separated -listen flags:
+type listenList []string
+
+func (l *listenList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenList) Set(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*l = append(*l, addr)
+		}
+	}
+	return nil
+}
+
+// Bind a socket to listen on, replacing a stale unix socket and applying TLS and socket permissions:
+func listen(network, addr string, tlsConfig *tls.Config, socketMode string, socketPerm os.FileMode) (net.Listener, error) {
+	// NOTE(jsd): Unix sockets must be unlink()ed before being reused again.
+	if network == "unix" {
+		// Clean up a stale socket left behind by an unclean shutdown, but never remove anything else:
+		if fi, err := os.Lstat(addr); err == nil && (fi.Mode()&os.ModeSocket) != 0 {
+			if err := os.Remove(addr); err != nil {
+				return nil, err
+			}
+			log.Printf("Removed stale unix socket '%s'", addr)
+		}
+	}
+
+	// Create the socket to listen on:
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	// Set the unix socket's permissions so e.g. nginx running as another user can connect:
+	if network == "unix" && socketMode != "" {
+		if err := os.Chmod(addr, socketPerm); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Check that an address could be listened on, without disturbing a server already using it:
+func checkListen(network, addr string) error {
+	if network == "unix" {
+		// An existing socket would be replaced at startup; otherwise its directory must exist:
+		if fi, err := os.Lstat(addr); err == nil {
+			if (fi.Mode() & os.ModeSocket) == 0 {
+				return fmt.Errorf("'%s' exists and is not a unix socket", addr)
+			}
+			return nil
+		}
+		fi, err := os.Stat(filepath.Dir(addr))
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("'%s' is not a directory", filepath.Dir(addr))
+		}
+		return nil
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+// Parse a listen address such as "unix:/path/to/socket", "tcp://:8080" or a bare ":8080" (tcp):
+func parseListenAddr(s string) (network, addr string, err error) {
+	if s == "" {
+		return "", "", fmt.Errorf("empty listen address")
+	}
+
+	if i := strings.Index(s, ":"); i > 0 {
+		scheme := s[:i]
+		rest := s[i+1:]
+		switch scheme {
+		case "unix":
+			// Accept both "unix:/path" and "unix:///path":
+			if startsWith(rest, "//") {
+				rest = rest[2:]
+			}
+			if rest == "" {
+				return "", "", fmt.Errorf("missing socket path in listen address %q", s)
+			}
+			return "unix", rest, nil
+		case "tcp", "tcp4", "tcp6":
+			if !startsWith(rest, "//") {
+				return "", "", fmt.Errorf("expected %s://host:port in listen address %q", scheme, s)
+			}
+			return scheme, rest[2:], nil
+		}
+	}
+
+	// Bare paths are unix sockets, anything else is a TCP host:port:
+	if path.IsAbs(s) {
+		return "unix", s, nil
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q: %s", s, err)
+	}
+	return "tcp", s, nil
 }
 
 func main() {
+	srv := &Server{timeLocation: time.UTC}
+
 	var socketType string
 	var socketAddr string
-
-	// TODO(jsd): Make this pair of arguments a little more elegant, like "unix:/path/to/socket" or "tcp://:8080"
-	flag.StringVar(&socketType, "l", "tcp", `type of socket to listen on; "unix" or "tcp" (default)`)
-	flag.StringVar(&socketAddr, "a", ":8080", `address to listen on; ":8080" (default TCP port) or "/path/to/unix/socket"`)
+	var proxyRoot, jailRoot, accelRedirect string
+	var listenAddrs listenList
+	var timeZone string
+	var accessLogPath string
+	var metricsAddr string
+	var tlsCert, tlsKey string
+	var shutdownTimeout time.Duration
+	var authUser, authPass, htpasswd string
+	var cacheSize int
+	var socketMode string
+	var customCSS, headerHtml, footerHtml string
+	var errorTemplate string
+	var dotAllow string
+	var favicon string
+	var showVersion bool
+	var check bool
+	var maxConcurrent int
+	var configFile string
+	var logFormat, logLevelName string
+
+	flag.BoolVar(&showVersion, "version", false, "print the version, commit and build date and exit")
+	flag.StringVar(&configFile, "config", "", "JSON file of flag values, keyed by flag name; flags given on the command line take precedence")
+	flag.BoolVar(&check, "check", false, "validate the configuration, print a summary and exit without serving; the exit status is nonzero if invalid")
+	flag.Var(&listenAddrs, "listen", `address to listen on, e.g. "tcp://:8080", "unix:/path/to/socket" or ":8080"; may be repeated or comma-separated; overrides -l and -a`)
+	flag.StringVar(&socketType, "l", "tcp", `deprecated: use -listen; type of socket to listen on; "unix" or "tcp" (default)`)
+	flag.StringVar(&socketAddr, "a", ":8080", `deprecated: use -listen; address to listen on; ":8080" (default TCP port) or "/path/to/unix/socket"`)
 	flag.StringVar(&proxyRoot, "p", "/", "root of web requests to process")
 	flag.StringVar(&jailRoot, "r", ".", "local filesystem path to bind to web request root path")
 	flag.StringVar(&accelRedirect, "xa", "", "Root of X-Accel-Redirect paths to use)")
+	flag.StringVar(&srv.accelHeader, "accel-header", "X-Accel-Redirect", `name of the file offload header; "X-Sendfile" or "X-LIGHTTPD-send-file" send the absolute local path and need no -xa`)
+	flag.StringVar(&srv.defaultContentType, "default-content-type", "application/octet-stream", "Content-Type of served files with unknown extensions; if empty, it's sniffed from their contents")
+	flag.BoolVar(&srv.accelContentLength, "accel-content-length", false, "set Content-Length on X-Accel-Redirect responses")
+	flag.Var(&srv.mounts, "mount", "additional mount of the form proxyPath=localPath[=accelRedirect]; may be repeated")
+	flag.StringVar(&logFormat, "log-format", "text", `format of error and access logs: "text" (access logs in Combined Log Format) or "json" (one object per line)`)
+	flag.StringVar(&logLevelName, "log-level", "info", `how much to log: "error" for failures only, "info" to add access logs, or "debug" to add per-request details`)
+	flag.StringVar(&srv.requestIDHeader, "request-id-header", "X-Request-Id", "header to take each request's ID from, or generate it for if absent, echo in the response and include in logs; disabled if empty")
+	flag.StringVar(&accessLogPath, "access-log", "-", `file to append Combined Log Format access logs to; "-" (default) for stdout, "" to disable`)
+	flag.StringVar(&socketMode, "socket-mode", "", `octal permissions to set on a unix socket after binding, e.g. "0660"; no effect for TCP`)
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM certificate file to serve HTTPS with; requires -tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key file to serve HTTPS with; requires -tls-cert")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests to finish when shutting down")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", `TCP address to serve Prometheus metrics on at /metrics, e.g. "127.0.0.1:9100"; disabled if empty`)
+	flag.StringVar(&authUser, "auth-user", "", "require HTTP Basic authentication with this user name; requires -auth-pass")
+	flag.StringVar(&authPass, "auth-pass", "", "password for -auth-user")
+	flag.StringVar(&htpasswd, "htpasswd", "", "require HTTP Basic authentication against this htpasswd file ({SHA} or plaintext passwords)")
+	flag.StringVar(&srv.healthPath, "health-path", "/healthz", "path answering liveness probes with 200 OK, independent of -p; disabled if empty")
+	flag.StringVar(&srv.faviconPath, "favicon-path", "/favicon.ico", "path answering favicon requests, independent of -p, unless -favicon is empty and a mount has that file; disabled if empty so they reach the mounts")
+	flag.StringVar(&favicon, "favicon", "", `icon served at -favicon-path: a file path, "builtin" for the embedded icon, or empty to answer 204 No Content`)
+	flag.StringVar(&srv.defaultSort, "default-sort", "name-asc", `sort mode for directories without an .index-sort file or ?sort= parameter, e.g. "date-desc"`)
+	flag.Var(&srv.sortHints, "sort-hint", `default sort for directories whose names match a glob, e.g. "logs=date-desc" or "*-backups=date-desc"; may be repeated, first match wins`)
+	flag.BoolVar(&srv.trustProxy, "trust-proxy", false, "trust X-Real-IP, X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host from a reverse proxy")
+	flag.BoolVar(&srv.strict, "strict", false, "reject unknown ?sort= values with 400 Bad Request instead of ignoring them")
+	flag.BoolVar(&srv.debug, "debug", false, "include detailed error messages in responses (development only)")
+	flag.StringVar(&srv.corsOrigin, "cors-origin", "", `value of the Access-Control-Allow-Origin header for listings, e.g. "*"; disabled if empty`)
+	flag.DurationVar(&srv.dirTimeout, "dir-timeout", 0, `give up reading a directory after this long with 504 Gateway Timeout, e.g. "10s"; 0 waits forever`)
+	flag.Int64Var(&srv.maxBodySize, "max-body-size", 64*1024, "maximum size in bytes of request bodies, e.g. of PROPFIND requests")
+	flag.IntVar(&maxConcurrent, "max-concurrent", 0, "maximum number of requests served at once, answering others with 503 Service Unavailable; 0 is unlimited")
+	flag.IntVar(&srv.maxEntries, "max-entries", 0, "maximum number of entries to list per directory, noting how many more were left out; 0 lists all")
+	flag.IntVar(&cacheSize, "cache-size", 0, "number of rendered directory listings to cache in memory; 0 disables caching")
+	flag.DurationVar(&srv.listingCacheTTL, "cache-ttl", time.Minute, "maximum age of a cached directory listing; 0 keeps listings until the directory changes")
+	flag.StringVar(&srv.indexFile, "index-file", "", `name of an index file, e.g. "index.html", to serve for directories containing one instead of a generated listing`)
+	flag.BoolVar(&srv.allowAdmin, "allow-admin", false, "accept authenticated POST ?set-sort=<sort-method> on directories to write their .index-sort file; requires -auth-user or -htpasswd")
+	flag.BoolVar(&srv.allowSearch, "allow-search", false, "allow ?search=<term> on directories to find matching names in the whole subtree")
+	flag.IntVar(&srv.searchMaxDepth, "search-max-depth", 10, "how many directory levels deep ?search= looks")
+	flag.IntVar(&srv.searchMaxResults, "search-max-results", 1000, "how many matches ?search= returns at most")
+	flag.DurationVar(&srv.searchTimeout, "search-timeout", 10*time.Second, "how long ?search= may walk a subtree before returning what it found; 0 waits forever")
+	flag.BoolVar(&srv.allowDu, "allow-du", false, "allow ?format=du and ?format=du-json on directories to report the total size of each subdirectory")
+	flag.IntVar(&srv.duMaxDepth, "du-max-depth", 5, "deepest ?depth= allowed for ?format=du reports")
+	flag.DurationVar(&srv.duCacheTTL, "du-cache-ttl", time.Minute, "maximum age of a cached subtree size for ?format=du reports; 0 disables caching")
+	flag.IntVar(&srv.duMaxEntries, "du-max-entries", 100000, "how many entries a ?format=du report counts at most before giving partial sizes")
+	flag.BoolVar(&srv.allowChecksum, "allow-checksum", false, "allow ?checksum=sha256 or ?checksum=md5 on file URLs and link to SHA-256 checksums in listings")
+	flag.BoolVar(&srv.showOwner, "show-owner", false, "add an Owner column showing each entry's user and group (unix only)")
+	flag.BoolVar(&srv.friendlyTypes, "friendly-types", true, `show common file types by name, e.g. "Word Document", with the MIME type in a tooltip; -friendly-types=false shows MIME types`)
+	flag.BoolVar(&srv.showMode, "show-mode", false, "add a Permissions column showing each entry's mode bits (e.g. -rw-r--r--)")
+	flag.BoolVar(&srv.clientSort, "client-sort", false, "sort listings in the browser when column headers are clicked, without reloading")
+	flag.StringVar(&srv.theme, "theme", "light", `color theme of HTML listings: "light", "dark", or "auto" to follow the browser's preference`)
+	flag.StringVar(&srv.assetsPrefix, "assets-prefix", "", `reserved URL path to serve the embedded CSS and JavaScript from, e.g. "/__assets__/"; if empty, they're inlined into each listing`)
+	flag.BoolVar(&srv.externalAssets, "external-assets", false, "link listings to Bootstrap on its CDN instead of inlining the embedded stylesheet")
+	flag.StringVar(&errorTemplate, "error-template", "", "HTML template file to render 4xx/5xx error pages with; see README for its fields")
+	flag.StringVar(&srv.csp, "csp", "auto", `Content-Security-Policy header for listings; "auto" allows just what listings load, empty disables it`)
+	flag.StringVar(&srv.referrerPolicy, "referrer-policy", "same-origin", "Referrer-Policy header for listings; disabled if empty")
+	flag.StringVar(&srv.frameOptions, "frame-options", "DENY", `X-Frame-Options header for listings, e.g. "SAMEORIGIN"; disabled if empty`)
+	flag.StringVar(&customCSS, "custom-css", "", "stylesheet to add to HTML listings; a file path is inlined, anything else is linked as a URL")
+	flag.StringVar(&headerHtml, "header-html", "", "HTML to insert at the top of listings' <body>, or a file containing it")
+	flag.StringVar(&footerHtml, "footer-html", "", "HTML to insert at the end of listings' <body>, or a file containing it")
+	flag.BoolVar(&srv.thumbnails, "thumbnails", false, "show image thumbnails in listings and serve them for ?thumb=1&w=<width> (CPU-heavy)")
+	flag.StringVar(&srv.thumbnailDir, "thumbnail-dir", filepath.Join(os.TempDir(), "index-html-thumbnails"), "directory to cache generated thumbnails in")
+	flag.StringVar(&srv.symlinkMode, "symlink-mode", "redirect", `how to serve requests for symlinks within the jail: "redirect" to the target's URL, or "follow" to serve the target in place`)
+	flag.BoolVar(&srv.compress, "compress", true, "brotli- or gzip-compress listings for clients that accept it; pass -compress=false to debug responses")
+	flag.BoolVar(&srv.precompressed, "precompressed", false, "serve file.br or file.gz instead of file when present and accepted by the client")
+	flag.BoolVar(&srv.showDotDirs, "show-dotdirs", false, "list directories whose names start with a dot, e.g. .well-known")
+	flag.StringVar(&dotAllow, "dotfile-allow", "", `comma-separated dot-prefixed names to list anyway, e.g. ".well-known,.htaccess"`)
+	flag.BoolVar(&srv.showLinkIndicator, "show-link-indicator", false, "mark followed symlinks in listings with a badge showing their target on hover")
+	flag.BoolVar(&srv.showSymlinks, "show-symlinks", false, "list symlinks with their targets instead of transparently resolving them")
+	flag.BoolVar(&srv.relativeTime, "relative-time", false, `display last modified times relative to now, e.g. "3 days ago"`)
+	flag.StringVar(&srv.timeFormat, "time-format", defaultTimeFormat, "Go time layout used to display last modified times")
+	flag.StringVar(&timeZone, "timezone", "UTC", `IANA time zone to display last modified times in, e.g. "America/New_York"`)
 	flag.Parse()
 
-	// Create the socket to listen on:
-	l, err := net.Listen(socketType, socketAddr)
+	explicitFlags := commandLineFlags()
+	if configFile != "" {
+		if err := loadConfigFile(configFile, explicitFlags, nil); err != nil {
+			log.Fatal(err)
+			return
+		}
+	}
+
+	if showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	switch logFormat {
+	case "text":
+	case "json":
+		srv.jsonLog = &jsonLogWriter{w: os.Stderr}
+		log.SetFlags(0)
+		log.SetOutput(srv.jsonLog)
+	default:
+		log.Fatalf("Invalid -log-format '%s': expected text or json", logFormat)
+		return
+	}
+	level, ok := logLevelNames[logLevelName]
+	if !ok {
+		log.Fatalf("Invalid -log-level '%s': expected error, info or debug", logLevelName)
+		return
+	}
+	srv.logLevel = level
+	log.Print(versionString())
+
+	// Mount -p/-r/-xa unless only -mount flags were given:
+	explicitRoot := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "p" || f.Name == "r" || f.Name == "xa" {
+			explicitRoot = true
+		}
+	})
+	if len(srv.mounts) == 0 || explicitRoot {
+		srv.mounts = append(srv.mounts, &mount{proxyRoot: proxyRoot, jailRoot: jailRoot, accelRedirect: accelRedirect})
+	}
+
+	// Check each jail root up front and make it absolute for consistent jail checks; symlinks in it are resolved
+	// too, since link targets are compared against it only after resolving theirs:
+	for _, m := range srv.mounts {
+		m.proxyRoot = cleanProxyRoot(m.proxyRoot)
+		root, err := filepath.Abs(m.jailRoot)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			log.Fatalf("Filesystem root '%s' is not usable: %s", m.jailRoot, err)
+			return
+		}
+		fi, err := os.Stat(root)
+		if err != nil {
+			log.Fatalf("Filesystem root '%s' is not usable: %s", m.jailRoot, err)
+			return
+		}
+		if !fi.IsDir() {
+			log.Fatalf("Filesystem root '%s' is not a directory", m.jailRoot)
+			return
+		}
+		m.jailRoot = root
+	}
+
+	if cacheSize > 0 {
+		srv.listingCache = newLRUCache(cacheSize)
+	}
+	if srv.allowChecksum {
+		srv.checksumCache = newLRUCache(4096)
+	}
+	if srv.allowDu && srv.duCacheTTL > 0 {
+		srv.duCache = newLRUCache(10000)
+	}
+	if maxConcurrent > 0 {
+		srv.requestSlots = make(chan struct{}, maxConcurrent)
+	}
+
+	if _, _, ok := parseSort(srv.defaultSort); !ok {
+		log.Fatalf("Invalid -default-sort '%s': expected name, date or size followed by -asc or -desc", srv.defaultSort)
+		return
+	}
+
+	if srv.symlinkMode != "redirect" && srv.symlinkMode != "follow" {
+		log.Fatalf("Invalid -symlink-mode '%s': expected redirect or follow", srv.symlinkMode)
+		return
+	}
+
+	if !validTheme(srv.theme) {
+		log.Fatalf("Invalid -theme '%s': expected light, dark or auto", srv.theme)
+		return
+	}
+
+	// Collect the dot-prefixed names to list:
+	srv.dotAllow = map[string]bool{}
+	for _, name := range strings.Split(dotAllow, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			srv.dotAllow[name] = true
+		}
+	}
+
+	// Load the settings that SIGHUP re-reads:
+	reloadOpts := func() reloadOptions {
+		return reloadOptions{
+			htpasswd:      htpasswd,
+			authUser:      authUser,
+			authPass:      authPass,
+			errorTemplate: errorTemplate,
+			customCSS:     customCSS,
+			headerHtml:    headerHtml,
+			footerHtml:    footerHtml,
+		}
+	}
+	live, err := srv.loadLiveConfig(reloadOpts())
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
+	srv.setLiveConfig(live)
+	if srv.allowAdmin && live.auth == nil {
+		log.Fatal("-allow-admin requires -auth-user or -htpasswd")
+		return
+	}
 
-	// NOTE(jsd): Unix sockets must be unlink()ed before being reused again.
+	if srv.favicon, srv.faviconName, err = loadFavicon(favicon); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	// Create the thumbnail cache:
+	if srv.thumbnails {
+		if err := os.MkdirAll(srv.thumbnailDir, 0700); err != nil {
+			log.Fatal(err)
+			return
+		}
+		srv.thumbnailSlots = make(chan struct{}, runtime.NumCPU())
+	}
+
+	// Normalize the assets prefix to "/name/":
+	if srv.assetsPrefix != "" {
+		srv.assetsPrefix = "/" + strings.Trim(srv.assetsPrefix, "/") + "/"
+	}
+
+	if srv.timeFormat == "" {
+		srv.timeFormat = defaultTimeFormat
+	}
+
+	// Open the access log:
+	switch accessLogPath {
+	case "":
+	case "-":
+		srv.accessLog = os.Stdout
+	default:
+		af, err := os.OpenFile(accessLogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		defer af.Close()
+		srv.accessLog = af
+	}
+
+	// Load the display time zone once:
+	if timeZone != "" {
+		loc, err := time.LoadLocation(timeZone)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		srv.timeLocation = loc
+	}
+
+	// Serve metrics on their own listener so they aren't reachable through the proxy:
+	if metricsAddr != "" && !check {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", serveMetrics)
+		go func() {
+			log.Fatal(http.ListenAndServe(metricsAddr, mux))
+		}()
+	}
+
+	// Determine the sockets to listen on:
+	type listenSocket struct{ network, addr string }
+	var sockets []listenSocket
+	for _, addr := range listenAddrs {
+		network, addr, err := parseListenAddr(addr)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		sockets = append(sockets, listenSocket{network, addr})
+	}
+	if len(sockets) == 0 {
+		sockets = append(sockets, listenSocket{socketType, socketAddr})
+	}
+
+	// Parse the unix socket permissions:
+	var socketPerm os.FileMode
+	if socketMode != "" {
+		mode, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil {
+			log.Fatalf("Invalid -socket-mode '%s': %s", socketMode, err)
+			return
+		}
+		socketPerm = os.FileMode(mode)
+	}
+
+	// Load the TLS certificate up front so misconfiguration fails fast:
+	var tlsConfig *tls.Config
+	if tlsCert != "" || tlsKey != "" {
+		if tlsCert == "" || tlsKey == "" {
+			log.Fatal("-tls-cert and -tls-key must be given together")
+			return
+		}
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
+	}
+
+	// Report what was validated instead of serving:
+	if check {
+		for _, sock := range sockets {
+			if err := checkListen(sock.network, sock.addr); err != nil {
+				log.Fatal(err)
+				return
+			}
+		}
+		if metricsAddr != "" {
+			if err := checkListen("tcp", metricsAddr); err != nil {
+				log.Fatal(err)
+				return
+			}
+		}
+
+		fmt.Println("Configuration OK:")
+		for _, m := range srv.mounts {
+			if m.accelRedirect != "" {
+				fmt.Printf("  mount %s => %s (%s %s)\n", m.proxyRoot, m.jailRoot, srv.accelHeader, m.accelRedirect)
+			} else {
+				fmt.Printf("  mount %s => %s\n", m.proxyRoot, m.jailRoot)
+			}
+		}
+		for _, sock := range sockets {
+			fmt.Printf("  listen %s %s\n", sock.network, sock.addr)
+		}
+		if metricsAddr != "" {
+			fmt.Printf("  metrics tcp %s\n", metricsAddr)
+		}
+		if tlsConfig != nil {
+			fmt.Printf("  TLS certificate %s\n", tlsCert)
+		}
+		if auth := srv.live().auth; auth != nil {
+			fmt.Printf("  Basic authentication for %d user(s)\n", len(auth))
+		}
+		if errorTemplate != "" {
+			fmt.Printf("  error template %s\n", errorTemplate)
+		}
+		return
+	}
 
-	// Handle common process-killing signals so we can gracefully shut down:
+	// Bind every socket before serving any, so a bad address fails fast:
+	listeners := make([]net.Listener, 0, len(sockets))
+	for _, sock := range sockets {
+		l, err := listen(sock.network, sock.addr, tlsConfig, socketMode, socketPerm)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		listeners = append(listeners, l)
+	}
+
+	server := &http.Server{Handler: srv}
+
+	// Re-read the config file's reloadable values and the files they name:
+	reload := func() error {
+		if configFile != "" {
+			if err := loadConfigFile(configFile, explicitFlags, reloadableFlags); err != nil {
+				return err
+			}
+		}
+		live, err := srv.loadLiveConfig(reloadOpts())
+		if err != nil {
+			return err
+		}
+		srv.setLiveConfig(live)
+		return nil
+	}
+
+	// Handle common process-killing signals so we can gracefully shut down, and SIGHUP to reload:
+	done := make(chan struct{})
 	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, os.Interrupt, os.Kill, syscall.SIGTERM)
+	signal.Notify(sigc, os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGHUP)
 	go func(c chan os.Signal) {
-		// Wait for a signal:
+		defer close(done)
+
+		// Wait for a signal, reloading on SIGHUP:
 		sig := <-c
+		for sig == syscall.SIGHUP {
+			if err := reload(); err != nil {
+				log.Printf("Reload failed, keeping the current settings: %s", err)
+			} else {
+				log.Print("Reloaded settings")
+			}
+			sig = <-c
+		}
 		log.Printf("Caught signal '%s': shutting down.", sig)
-		// Stop listening:
-		l.Close()
-		// Delete the unix socket, if applicable:
-		if socketType == "unix" {
-			os.Remove(socketAddr)
-		}
-		// And we're done:
-		os.Exit(0)
+
+		// Stop listening and let in-flight requests finish:
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %s", err)
+			server.Close()
+		}
+
+		// Delete the unix sockets, if applicable:
+		for _, sock := range sockets {
+			if sock.network == "unix" {
+				os.Remove(sock.addr)
+			}
+		}
 	}(sigc)
 
-	// Start the HTTP server:
-	log.Fatal(http.Serve(l, http.HandlerFunc(processRequest)))
+	// Start the HTTP server on every listener; Shutdown closes them all:
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errc <- server.Serve(l)
+		}(l)
+	}
+	for range listeners {
+		if err := <-errc; err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}
+
+	// And we're done once shutdown completes:
+	<-done
 }
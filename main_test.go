@@ -0,0 +1,603 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Requests that are meant to fail would otherwise clutter the test output:
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// Create a Server with the flag defaults, serving dir at "/":
+func newTestServer(t *testing.T, dir string) *Server {
+	t.Helper()
+
+	// Jail roots are resolved at startup, e.g. /tmp may be a symlink:
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		mounts:             mountList{{proxyRoot: "/", jailRoot: root}},
+		accelHeader:        "X-Accel-Redirect",
+		symlinkMode:        "redirect",
+		defaultSort:        "name-asc",
+		defaultContentType: "application/octet-stream",
+		friendlyTypes:      true,
+		theme:              "light",
+		maxBodySize:        64 << 10,
+		dotAllow:           map[string]bool{},
+		timeFormat:         defaultTimeFormat,
+		timeLocation:       time.UTC,
+		logLevel:           levelError,
+	}
+	s.setLiveConfig(&liveConfig{})
+	return s
+}
+
+// Send a request with a raw request URI, as the proxy would, and record the response:
+func serveRequest(s *Server, method, requestURI string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", nil)
+	req.RequestURI = requestURI
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rsp := httptest.NewRecorder()
+	s.ServeHTTP(rsp, req)
+	return rsp
+}
+
+// Create files (or directories, for names ending in "/") under dir:
+func writeTree(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTraversalRejected(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt", "sub/b.txt")
+	s := newTestServer(t, dir)
+
+	tests := []struct {
+		requestURI string
+		want       int
+	}{
+		{"/a.txt", http.StatusOK},
+		{"/sub/../a.txt", http.StatusBadRequest},
+		{"/../etc/passwd", http.StatusBadRequest},
+		{"/sub/%2e%2e/a.txt", http.StatusBadRequest},
+		{"/%2E%2E/%2e%2e/etc/passwd", http.StatusBadRequest},
+		{"/a.txt%00.jpg", http.StatusBadRequest},
+		{"/sub/%00", http.StatusBadRequest},
+		// Names that merely contain dots are fine:
+		{"/sub/..b.txt", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rsp := serveRequest(s, "GET", tt.requestURI, nil); rsp.Code != tt.want {
+			t.Errorf("GET %s: got %d, want %d", tt.requestURI, rsp.Code, tt.want)
+		}
+	}
+}
+
+func TestIsUnsafePath(t *testing.T) {
+	tests := []struct {
+		p    string
+		want bool
+	}{
+		{"/", false},
+		{"/a/b.txt", false},
+		{"/a..b/c", false},
+		{"/...", false},
+		{"/..", true},
+		{"/a/../b", true},
+		{"../a", true},
+		{"/a\x00b", true},
+	}
+	for _, tt := range tests {
+		if got := isUnsafePath(tt.p); got != tt.want {
+			t.Errorf("isUnsafePath(%q) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+// Create a symlink, skipping the test where that isn't possible, e.g. on Windows without privileges:
+func symlink(t *testing.T, target, name string) {
+	t.Helper()
+	if err := os.Symlink(target, name); err != nil {
+		t.Skipf("can't create symlinks: %s", err)
+	}
+}
+
+func TestSymlinkEscapingJailRejected(t *testing.T) {
+	base := t.TempDir()
+	jail := filepath.Join(base, "jail")
+	writeTree(t, base, "outside/secret.txt", "jail/a.txt", "jail/deep/")
+	symlink(t, "../outside/secret.txt", filepath.Join(jail, "up"))
+	symlink(t, "../../outside/secret.txt", filepath.Join(jail, "deep", "climb"))
+	symlink(t, "../deep/../../outside", filepath.Join(jail, "deep", "dir"))
+	symlink(t, "a.txt", filepath.Join(jail, "ok"))
+
+	for _, mode := range []string{"redirect", "follow"} {
+		s := newTestServer(t, jail)
+		s.symlinkMode = mode
+
+		for _, p := range []string{"/up", "/deep/climb", "/deep/dir", "/deep/dir/secret.txt"} {
+			if rsp := serveRequest(s, "GET", p, nil); rsp.Code != http.StatusBadRequest {
+				t.Errorf("%s: GET %s: got %d, want %d", mode, p, rsp.Code, http.StatusBadRequest)
+			}
+		}
+	}
+
+	// Links within the jail still work:
+	s := newTestServer(t, jail)
+	rsp := serveRequest(s, "GET", "/ok", nil)
+	if rsp.Code != http.StatusFound || rsp.Header().Get("Location") != "/a.txt" {
+		t.Errorf("GET /ok: got %d to %q, want %d to /a.txt", rsp.Code, rsp.Header().Get("Location"), http.StatusFound)
+	}
+}
+
+func TestSymlinkToControlFileRefused(t *testing.T) {
+	jail := t.TempDir()
+	writeTree(t, jail, "pub/", "priv/")
+	if err := os.WriteFile(filepath.Join(jail, "priv", indexAuthFile), []byte("user:secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, "../priv/"+indexAuthFile, filepath.Join(jail, "pub", "creds"))
+
+	s := newTestServer(t, jail)
+	s.symlinkMode = "follow"
+	rsp := serveRequest(s, "GET", "/pub/creds", nil)
+	if rsp.Code != http.StatusNotFound {
+		t.Errorf("GET /pub/creds: got %d, want %d", rsp.Code, http.StatusNotFound)
+	}
+	if strings.Contains(rsp.Body.String(), "secret") {
+		t.Errorf("GET /pub/creds: body leaks the access control file")
+	}
+}
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		s       string
+		network string
+		addr    string
+		wantErr bool
+	}{
+		{s: "unix:/tmp/s.sock", network: "unix", addr: "/tmp/s.sock"},
+		{s: "unix:///tmp/s.sock", network: "unix", addr: "/tmp/s.sock"},
+		{s: "/tmp/s.sock", network: "unix", addr: "/tmp/s.sock"},
+		{s: "tcp://:8080", network: "tcp", addr: ":8080"},
+		{s: "tcp://127.0.0.1:8080", network: "tcp", addr: "127.0.0.1:8080"},
+		{s: "tcp6://[::1]:8080", network: "tcp6", addr: "[::1]:8080"},
+		{s: ":9000", network: "tcp", addr: ":9000"},
+		{s: "localhost:9000", network: "tcp", addr: "localhost:9000"},
+		{s: "", wantErr: true},
+		{s: "unix:", wantErr: true},
+		{s: "tcp:8080", wantErr: true},
+		{s: "9000", wantErr: true},
+	}
+	for _, tt := range tests {
+		network, addr, err := parseListenAddr(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseListenAddr(%q) = %q, %q; want an error", tt.s, network, addr)
+			}
+			continue
+		}
+		if err != nil || network != tt.network || addr != tt.addr {
+			t.Errorf("parseListenAddr(%q) = %q, %q, %v; want %q, %q", tt.s, network, addr, err, tt.network, tt.addr)
+		}
+	}
+}
+
+// Request a JSON listing and decode it:
+func getJSONListing(t *testing.T, s *Server, requestURI string) (*httptest.ResponseRecorder, jsonListing) {
+	t.Helper()
+	var listing jsonListing
+	rsp := serveRequest(s, "GET", requestURI, nil)
+	if rsp.Code == http.StatusOK {
+		if err := json.Unmarshal(rsp.Body.Bytes(), &listing); err != nil {
+			t.Fatalf("GET %s: %s", requestURI, err)
+		}
+	}
+	return rsp, listing
+}
+
+func TestSortTokens(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt", "sorted/b.txt")
+	if err := os.WriteFile(filepath.Join(dir, "sorted", indexSortFile), []byte("size-desc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, strict := range []bool{false, true} {
+		s := newTestServer(t, dir)
+		s.strict = strict
+
+		// Every valid token is used as given:
+		for _, by := range []string{"name", "date", "size"} {
+			for _, order := range []string{"asc", "desc"} {
+				token := by + "-" + order
+				rsp, listing := getJSONListing(t, s, "/?format=json&sort="+token)
+				if rsp.Code != http.StatusOK || listing.Sort != token {
+					t.Errorf("strict %v: sort=%s: got %d sorted by %q", strict, token, rsp.Code, listing.Sort)
+				}
+			}
+		}
+
+		// Invalid tokens are refused with -strict, or else ignored in favor of .index-sort or the default:
+		for _, tt := range []struct {
+			dir  string
+			want string
+		}{
+			{"/", "name-asc"},
+			{"/sorted/", "size-desc"},
+		} {
+			for _, token := range []string{"sise-desc", "name", "name-up", "manual", "NAME-ASC"} {
+				rsp, listing := getJSONListing(t, s, tt.dir+"?format=json&sort="+token)
+				if strict {
+					if rsp.Code != http.StatusBadRequest {
+						t.Errorf("strict: %s?sort=%s: got %d, want %d", tt.dir, token, rsp.Code, http.StatusBadRequest)
+					}
+				} else if rsp.Code != http.StatusOK || listing.Sort != tt.want {
+					t.Errorf("%s?sort=%s: got %d sorted by %q, want %q", tt.dir, token, rsp.Code, listing.Sort, tt.want)
+				}
+			}
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		s   string
+		by  sortBy
+		dir sortDirection
+		ok  bool
+	}{
+		{"name-asc", sortByName, sortAscending, true},
+		{"name-desc", sortByName, sortDescending, true},
+		{"date-asc", sortByDate, sortAscending, true},
+		{"date-desc", sortByDate, sortDescending, true},
+		{"size-asc", sortBySize, sortAscending, true},
+		{"size-desc", sortBySize, sortDescending, true},
+		{"sise-desc", sortByName, sortAscending, false},
+		{"size", sortByName, sortAscending, false},
+		{"size-", sortByName, sortAscending, false},
+		{"manual", sortByName, sortAscending, false},
+		{"", sortByName, sortAscending, false},
+	}
+	for _, tt := range tests {
+		by, dir, ok := parseSort(tt.s)
+		if by != tt.by || dir != tt.dir || ok != tt.ok {
+			t.Errorf("parseSort(%q) = %v, %v, %v; want %v, %v, %v", tt.s, by, dir, ok, tt.by, tt.dir, tt.ok)
+		}
+	}
+}
+
+func TestOutsideProxyRootNotFound(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt")
+	s := newTestServer(t, dir)
+	s.mounts[0].proxyRoot = "/files"
+
+	for _, p := range []string{"/", "/other/a.txt", "/filesystem/a.txt", "/a.txt"} {
+		rsp := serveRequest(s, "GET", p, nil)
+		if rsp.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want %d", p, rsp.Code, http.StatusNotFound)
+		}
+		if rsp.Body.Len() == 0 {
+			t.Errorf("GET %s: empty body", p)
+		}
+	}
+
+	if rsp := serveRequest(s, "GET", "/files/a.txt", nil); rsp.Code != http.StatusOK {
+		t.Errorf("GET /files/a.txt: got %d, want %d", rsp.Code, http.StatusOK)
+	}
+}
+
+func TestMalformedRequestURI(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "a.txt")
+	s := newTestServer(t, dir)
+
+	for _, requestURI := range []string{"/%zz", "/a.txt%", "http://[::1/", ":no-scheme"} {
+		if rsp := serveRequest(s, "GET", requestURI, nil); rsp.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: got %d, want %d", requestURI, rsp.Code, http.StatusBadRequest)
+		}
+	}
+
+	// The server carries on serving:
+	if rsp := serveRequest(s, "GET", "/a.txt", nil); rsp.Code != http.StatusOK {
+		t.Errorf("GET /a.txt: got %d, want %d", rsp.Code, http.StatusOK)
+	}
+}
+
+// A directory entry that needn't exist on disk, e.g. one with an empty name:
+type fakeFileInfo struct {
+	name string
+	mode os.FileMode
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return 0 }
+func (fi fakeFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fakeFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+func TestUnusualEntryNames(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	fis := []os.FileInfo{
+		fakeFileInfo{name: ""},
+		fakeFileInfo{name: ".hidden"},
+		fakeFileInfo{name: "."},
+		fakeFileInfo{name: "…ellipsis"},
+		fakeFileInfo{name: "äpfel.txt"},
+		fakeFileInfo{name: " leading space"},
+		fakeFileInfo{name: "-dash"},
+	}
+	entries, err := s.indexEntries(req, s.mounts[0], s.mounts[0].jailRoot, fis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+	if got, want := strings.Join(names, "|"), "…ellipsis|äpfel.txt| leading space|-dash"; got != want {
+		t.Errorf("listed %q, want %q", got, want)
+	}
+
+	// The same names on disk render without trouble:
+	writeTree(t, dir, "…ellipsis", "äpfel.txt", " leading space", "-dash", ".hidden")
+	rsp := serveRequest(s, "GET", "/", nil)
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rsp.Code, http.StatusOK)
+	}
+	for _, name := range []string{"…ellipsis", "äpfel.txt", "-dash"} {
+		if !strings.Contains(rsp.Body.String(), ">"+name+"</a>") {
+			t.Errorf("listing lacks %q", name)
+		}
+	}
+	if strings.Contains(rsp.Body.String(), ".hidden") {
+		t.Errorf("listing shows .hidden")
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		p    string
+		want string
+	}{
+		{"/a/b.txt", "/a/b.txt"},
+		{"/my file #2.txt", "/my%20file%20%232.txt"},
+		{"/what?.txt", "/what%3F.txt"},
+		{"/a&b.txt", "/a&b.txt"},
+		{"/c+d.txt", "/c+d.txt"},
+		{"/100%.txt", "/100%25.txt"},
+		{"/sp ace/dir/", "/sp%20ace/dir/"},
+		{"/äpfel", "/%C3%A4pfel"},
+	}
+	for _, tt := range tests {
+		if got := escapePath(tt.p); got != tt.want {
+			t.Errorf("escapePath(%q) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestSpecialCharacterLinks(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"my file #2.txt", "what?.txt", "a&b.txt", "c+d.txt", "100%.txt", "<tag>.txt"}
+	for _, name := range names {
+		// Some of these names aren't allowed everywhere, e.g. on Windows:
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Skipf("can't create %q: %s", name, err)
+		}
+	}
+	s := newTestServer(t, dir)
+
+	rsp := serveRequest(s, "GET", "/", nil)
+	if rsp.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rsp.Code, http.StatusOK)
+	}
+	links := map[string]string{}
+	for _, m := range regexp.MustCompile(`<td class="name" data-name="[^"]*"><a href="([^"]*)">([^<]*)</a>`).FindAllStringSubmatch(rsp.Body.String(), -1) {
+		links[html.UnescapeString(m[2])] = html.UnescapeString(m[1])
+	}
+
+	for _, name := range names {
+		href, ok := links[name]
+		if !ok {
+			t.Errorf("no link displayed as %q", name)
+			continue
+		}
+		// The href must lead back to exactly this file, without a query or fragment:
+		u, err := url.Parse(href)
+		if err != nil || u.Path != "/"+name || u.RawQuery != "" || u.Fragment != "" {
+			t.Errorf("%q links to %q", name, href)
+			continue
+		}
+		if rsp := serveRequest(s, "GET", href, nil); rsp.Code != http.StatusOK {
+			t.Errorf("GET %s: got %d, want %d", href, rsp.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestPathHasPrefix(t *testing.T) {
+	tests := []struct {
+		p, prefix string
+		want      bool
+	}{
+		{"/srv/data", "/srv/data", true},
+		{"/srv/data/", "/srv/data", true},
+		{"/srv/data/x", "/srv/data", true},
+		{"/srv/data/x", "/srv/data/", true},
+		{"/srv/data", "/", true},
+		{"/anything", "/", true},
+		// Siblings sharing a prefix aren't beneath it:
+		{"/srv/database", "/srv/data", false},
+		{"/srv/database/x", "/srv/data", false},
+		{"/srv/database/x", "/srv/data/", false},
+		{"/srv/data-old", "/srv/data", false},
+		{"/srv/dat", "/srv/data", false},
+		{"/srv", "/srv/data", false},
+	}
+	for _, tt := range tests {
+		if got := pathHasPrefix(tt.p, tt.prefix); got != tt.want {
+			t.Errorf("pathHasPrefix(%q, %q) = %v, want %v", tt.p, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestSiblingDirectoryOutsideJail(t *testing.T) {
+	base := t.TempDir()
+	writeTree(t, base, "data/a.txt", "database/secret.txt")
+	jail := filepath.Join(base, "data")
+	symlink(t, filepath.Join(base, "database", "secret.txt"), filepath.Join(jail, "sibling"))
+
+	// A link into the sibling directory is outside the jail, however similar its path:
+	for _, mode := range []string{"redirect", "follow"} {
+		s := newTestServer(t, jail)
+		s.symlinkMode = mode
+		if rsp := serveRequest(s, "GET", "/sibling", nil); rsp.Code != http.StatusBadRequest {
+			t.Errorf("%s: GET /sibling: got %d, want %d", mode, rsp.Code, http.StatusBadRequest)
+		}
+	}
+
+	// Nor does its target's size show in listings:
+	s := newTestServer(t, jail)
+	_, listing := getJSONListing(t, s, "/?format=json")
+	for _, e := range listing.Entries {
+		if e.Name == "sibling" && (e.Type != "symlink" || e.Size != nil) {
+			t.Errorf("sibling listed as %q with size %v", e.Type, e.Size)
+		}
+	}
+}
+
+func TestFaviconFromMount(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+	s.faviconPath = "/favicon.ico"
+
+	if rsp := serveRequest(s, "GET", "/favicon.ico", nil); rsp.Code != http.StatusNoContent {
+		t.Errorf("without a file: got %d, want %d", rsp.Code, http.StatusNoContent)
+	}
+
+	// A mount's own favicon is served rather than hidden:
+	writeTree(t, dir, "favicon.ico")
+	rsp := serveRequest(s, "GET", "/favicon.ico", nil)
+	if rsp.Code != http.StatusOK || rsp.Body.String() != "favicon.ico" {
+		t.Errorf("with a file: got %d %q, want %d with its content", rsp.Code, rsp.Body.String(), http.StatusOK)
+	}
+
+	// ...unless -favicon is set:
+	s.favicon, s.faviconName = []byte("icon"), "favicon.ico"
+	if rsp := serveRequest(s, "GET", "/favicon.ico", nil); rsp.Body.String() != "icon" {
+		t.Errorf("with -favicon: got %d %q, want the configured icon", rsp.Code, rsp.Body.String())
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f.bin"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "f.bin"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, dir)
+
+	tests := []struct {
+		header http.Header
+		code   int
+		body   string
+	}{
+		{http.Header{}, http.StatusOK, "0123456789"},
+		{http.Header{"Range": {"bytes=0-1"}}, http.StatusPartialContent, "01"},
+		{http.Header{"Range": {"bytes=8-"}}, http.StatusPartialContent, "89"},
+		// Resuming with the current validator gets the rest:
+		{http.Header{"Range": {"bytes=4-"}, "If-Range": {mtime.Format(http.TimeFormat)}}, http.StatusPartialContent, "456789"},
+		// A stale validator means the file changed, so the whole file is sent:
+		{http.Header{"Range": {"bytes=4-"}, "If-Range": {mtime.Add(-time.Hour).Format(http.TimeFormat)}}, http.StatusOK, "0123456789"},
+		{http.Header{"Range": {"bytes=20-"}}, http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, tt := range tests {
+		rsp := serveRequest(s, "GET", "/f.bin", tt.header)
+		if rsp.Code != tt.code || (tt.body != "" && rsp.Body.String() != tt.body) {
+			t.Errorf("GET /f.bin with %v: got %d %q, want %d %q", tt.header, rsp.Code, rsp.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	rsp := serveRequest(s, "GET", "/f.bin", http.Header{"Range": {"bytes=0-1"}})
+	if got := rsp.Header().Get("Content-Range"); got != "bytes 0-1/10" {
+		t.Errorf("Content-Range: got %q", got)
+	}
+	if got := serveRequest(s, "HEAD", "/f.bin", nil).Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges: got %q", got)
+	}
+}
+
+func TestSymlinkedDirectoryInPath(t *testing.T) {
+	base := t.TempDir()
+	jail := filepath.Join(base, "jail")
+	writeTree(t, base, "outside/secret.txt", "jail/sub/in.txt", "jail/priv/x.txt")
+	if err := os.WriteFile(filepath.Join(jail, "priv", indexAuthFile), []byte("u:p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, filepath.Join(base, "outside"), filepath.Join(jail, "dirlink"))
+	symlink(t, "sub", filepath.Join(jail, "sublink"))
+	symlink(t, "priv", filepath.Join(jail, "privlink"))
+	s := newTestServer(t, jail)
+
+	tests := []struct {
+		p    string
+		want int
+	}{
+		{"/dirlink/secret.txt", http.StatusBadRequest},
+		{"/dirlink/", http.StatusBadRequest},
+		{"/sublink/in.txt", http.StatusOK},
+		// A link as the last component is redirected as usual:
+		{"/sublink/", http.StatusFound},
+		// The real directory's .index-auth applies through the link:
+		{"/privlink/x.txt", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if rsp := serveRequest(s, "GET", tt.p, nil); rsp.Code != tt.want {
+			t.Errorf("GET %s: got %d, want %d", tt.p, rsp.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds (in seconds) of the index generation latency histogram buckets:
+var indexLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	lock    sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(v float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Counters exposed on the metrics endpoint:
+var metrics struct {
+	requests      atomic.Uint64
+	statusClasses [6]atomic.Uint64
+	bytesServed   atomic.Uint64
+	filesServed   atomic.Uint64
+	indexPages    atomic.Uint64
+	indexLatency  histogram
+}
+
+func init() {
+	metrics.indexLatency.buckets = indexLatencyBuckets
+	metrics.indexLatency.counts = make([]uint64, len(indexLatencyBuckets))
+}
+
+// Count a completed request by its status class and body size:
+func observeRequest(rec *statusRecorder) {
+	metrics.requests.Add(1)
+	if class := rec.Status() / 100; class > 0 && class < len(metrics.statusClasses) {
+		metrics.statusClasses[class].Add(1)
+	}
+	metrics.bytesServed.Add(uint64(rec.Bytes()))
+}
+
+// Count a generated index page and how long it took:
+func observeIndexPage(start time.Time) {
+	metrics.indexPages.Add(1)
+	metrics.indexLatency.observe(time.Since(start).Seconds())
+}
+
+// Serves the metrics in Prometheus text exposition format.
+func serveMetrics(rsp http.ResponseWriter, req *http.Request) {
+	rsp.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintf(rsp, "# HELP index_html_requests_total Total HTTP requests handled.\n")
+	fmt.Fprintf(rsp, "# TYPE index_html_requests_total counter\n")
+	fmt.Fprintf(rsp, "index_html_requests_total %d\n", metrics.requests.Load())
+
+	fmt.Fprintf(rsp, "# HELP index_html_responses_total HTTP responses by status class.\n")
+	fmt.Fprintf(rsp, "# TYPE index_html_responses_total counter\n")
+	for class := 1; class < len(metrics.statusClasses); class++ {
+		fmt.Fprintf(rsp, "index_html_responses_total{class=\"%dxx\"} %d\n", class, metrics.statusClasses[class].Load())
+	}
+
+	fmt.Fprintf(rsp, "# HELP index_html_response_bytes_total Total response body bytes written.\n")
+	fmt.Fprintf(rsp, "# TYPE index_html_response_bytes_total counter\n")
+	fmt.Fprintf(rsp, "index_html_response_bytes_total %d\n", metrics.bytesServed.Load())
+
+	fmt.Fprintf(rsp, "# HELP index_html_files_served_total Total file downloads served or handed off via X-Accel-Redirect.\n")
+	fmt.Fprintf(rsp, "# TYPE index_html_files_served_total counter\n")
+	fmt.Fprintf(rsp, "index_html_files_served_total %d\n", metrics.filesServed.Load())
+
+	fmt.Fprintf(rsp, "# HELP index_html_index_pages_total Total directory index pages generated.\n")
+	fmt.Fprintf(rsp, "# TYPE index_html_index_pages_total counter\n")
+	fmt.Fprintf(rsp, "index_html_index_pages_total %d\n", metrics.indexPages.Load())
+
+	h := &metrics.indexLatency
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fmt.Fprintf(rsp, "# HELP index_html_index_generation_seconds Time spent generating directory index pages.\n")
+	fmt.Fprintf(rsp, "# TYPE index_html_index_generation_seconds histogram\n")
+	for i, b := range h.buckets {
+		fmt.Fprintf(rsp, "index_html_index_generation_seconds_bucket{le=\"%g\"} %d\n", b, h.counts[i])
+	}
+	fmt.Fprintf(rsp, "index_html_index_generation_seconds_bucket{le=\"+Inf\"} %d\n", h.count)
+	fmt.Fprintf(rsp, "index_html_index_generation_seconds_sum %g\n", h.sum)
+	fmt.Fprintf(rsp, "index_html_index_generation_seconds_count %d\n", h.count)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Binds web requests under proxyRoot to the local filesystem under jailRoot:
+type mount struct {
+	proxyRoot     string
+	jailRoot      string
+	accelRedirect string
+}
+
+// Clean a proxy root to an absolute path without a trailing slash, so "/files", "/files/" and
+// "files" all mount at the same place:
+func cleanProxyRoot(p string) string {
+	return path.Clean("/" + p)
+}
+
+// Strip the jail root from a local path, leaving paths outside the jail untouched:
+func (m *mount) jailRelative(p string) string {
+	if !pathHasPrefix(p, m.jailRoot) {
+		return p
+	}
+	return removeIfStartsWith(p, strings.TrimSuffix(m.jailRoot, "/"))
+}
+
+func (m *mount) translateForProxy(s string) string {
+	return path.Join(m.proxyRoot, m.jailRelative(s))
+}
+
+// Describe a symlink's target for listings without revealing local paths: absolute targets within the jail
+// become URL paths, relative ones are shown as written, and targets outside the jail are hidden (""):
+func (m *mount) displayLinkTarget(linkPath, target string) string {
+	dest := target
+	if !path.IsAbs(dest) {
+		dest = path.Join(path.Dir(linkPath), dest)
+	}
+	dest = path.Clean(dest)
+	if resolved, err := filepath.EvalSymlinks(dest); err == nil {
+		dest = resolved
+	}
+
+	if !m.isInsideJail(dest) {
+		return ""
+	}
+	if path.IsAbs(target) {
+		return m.translateForProxy(dest)
+	}
+	return target
+}
+
+// Check if a local filesystem path lies within the mount's jail root:
+func (m *mount) isInsideJail(p string) bool {
+	rel, err := filepath.Rel(m.jailRoot, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !startsWith(rel, "../")
+}
+
+// Check if a request path lies under the mount's proxy root, on a path segment boundary:
+func (m *mount) containsPath(p string) bool {
+	return pathHasPrefix(p, m.proxyRoot)
+}
+
+// Find the mount with the longest proxy root matching the request path:
+func (s *Server) findMount(p string) *mount {
+	var best *mount
+	for _, m := range s.mounts {
+		if !m.containsPath(p) {
+			continue
+		}
+		if best == nil || len(m.proxyRoot) > len(best.proxyRoot) {
+			best = m
+		}
+	}
+	return best
+}
+
+// Collects repeated -mount flags of the form "proxyPath=localPath[=accelRedirect]":
+type mountList []*mount
+
+func (l *mountList) String() string {
+	s := make([]string, 0, len(*l))
+	for _, m := range *l {
+		if m.accelRedirect != "" {
+			s = append(s, fmt.Sprintf("%s=%s=%s", m.proxyRoot, m.jailRoot, m.accelRedirect))
+		} else {
+			s = append(s, fmt.Sprintf("%s=%s", m.proxyRoot, m.jailRoot))
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *mountList) Set(value string) error {
+	parts := strings.SplitN(value, "=", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected proxyPath=localPath[=accelRedirect], got %q", value)
+	}
+
+	m := &mount{proxyRoot: parts[0], jailRoot: parts[1]}
+	if len(parts) == 3 {
+		m.accelRedirect = parts[2]
+	}
+	*l = append(*l, m)
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTranslateForProxy(t *testing.T) {
+	tests := []struct {
+		proxyRoot, jailRoot string
+		p                   string
+		want                string
+	}{
+		{"/files", "/srv/data", "/srv/data/a/b", "/files/a/b"},
+		{"/files", "/srv/data", "/srv/data/a/b/", "/files/a/b"},
+		{"/files", "/srv/data/", "/srv/data/a/b", "/files/a/b"},
+		{"/files/", "/srv/data", "/srv/data/a", "/files/a"},
+		{"/", "/srv/data", "/srv/data/a", "/a"},
+		// The jail root itself:
+		{"/files", "/srv/data", "/srv/data", "/files"},
+		{"/files", "/srv/data", "/srv/data/", "/files"},
+		{"/", "/srv/data", "/srv/data", "/"},
+		// Paths outside the jail aren't stripped, not even of a sibling's shared prefix:
+		{"/files", "/srv/data", "/srv/database/x", "/files/srv/database/x"},
+		{"/files", "/srv/data", "/other/a", "/files/other/a"},
+		// Empty roots:
+		{"", "", "/a/b", "/a/b"},
+		{"/files", "", "/a/b", "/files/a/b"},
+		{"", "/srv/data", "/srv/data/a", "/a"},
+	}
+	for _, tt := range tests {
+		m := &mount{proxyRoot: tt.proxyRoot, jailRoot: tt.jailRoot}
+		if got := m.translateForProxy(tt.p); got != tt.want {
+			t.Errorf("proxyRoot %q, jailRoot %q: translateForProxy(%q) = %q, want %q", tt.proxyRoot, tt.jailRoot, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveIfStartsWith(t *testing.T) {
+	tests := []struct {
+		s, start string
+		want     string
+	}{
+		{"/files/a/b", "/files", "/a/b"},
+		{"/files/a/b", "/files/", "a/b"},
+		{"/files", "/files", ""},
+		{"/other/a", "/files", "/other/a"},
+		{"/fil", "/files", "/fil"},
+		{"/a", "", "/a"},
+		{"", "", ""},
+		// It's a plain string prefix; callers check path segments with pathHasPrefix first:
+		{"/filesystem", "/files", "ystem"},
+	}
+	for _, tt := range tests {
+		if got := removeIfStartsWith(tt.s, tt.start); got != tt.want {
+			t.Errorf("removeIfStartsWith(%q, %q) = %q, want %q", tt.s, tt.start, got, tt.want)
+		}
+	}
+}
+
+func TestJailRelative(t *testing.T) {
+	tests := []struct {
+		jailRoot string
+		p        string
+		want     string
+	}{
+		{"/srv/data", "/srv/data/a/b", "/a/b"},
+		{"/srv/data/", "/srv/data/a/b", "/a/b"},
+		{"/srv/data", "/srv/data", ""},
+		{"/srv/data", "/srv/data/", "/"},
+		{"/srv/data", "/srv/database", "/srv/database"},
+		{"/srv/data", "/srv/database/x", "/srv/database/x"},
+		{"/srv/data", "/srv/dat", "/srv/dat"},
+		{"/srv/data", "/srv", "/srv"},
+	}
+	for _, tt := range tests {
+		m := &mount{proxyRoot: "/", jailRoot: tt.jailRoot}
+		if got := m.jailRelative(tt.p); got != tt.want {
+			t.Errorf("jailRoot %q: jailRelative(%q) = %q, want %q", tt.jailRoot, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestIsInsideJail(t *testing.T) {
+	tests := []struct {
+		p    string
+		want bool
+	}{
+		{"/srv/data", true},
+		{"/srv/data/", true},
+		{"/srv/data/a/b", true},
+		{"/srv/data/..hidden", true},
+		{"/srv/data/a/../b", true},
+		{"/srv/database", false},
+		{"/srv/database/x", false},
+		{"/srv/dat", false},
+		{"/srv", false},
+		{"/", false},
+		{"/srv/data/../database", false},
+		{"/srv/data/a/../../x", false},
+	}
+	for _, tt := range tests {
+		m := &mount{proxyRoot: "/files", jailRoot: "/srv/data"}
+		if got := m.isInsideJail(tt.p); got != tt.want {
+			t.Errorf("isInsideJail(%q) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestCleanProxyRoot(t *testing.T) {
+	tests := []struct {
+		p    string
+		want string
+	}{
+		{"/files", "/files"},
+		{"/files/", "/files"},
+		{"files", "/files"},
+		{"files/", "/files"},
+		{"//files//", "/files"},
+		{"/a/./b/", "/a/b"},
+		{"/", "/"},
+		{"", "/"},
+	}
+	for _, tt := range tests {
+		if got := cleanProxyRoot(tt.p); got != tt.want {
+			t.Errorf("cleanProxyRoot(%q) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestFindMountSiblingPrefix(t *testing.T) {
+	data := &mount{proxyRoot: cleanProxyRoot("/data/"), jailRoot: "/srv/data"}
+	database := &mount{proxyRoot: cleanProxyRoot("database"), jailRoot: "/srv/database"}
+	root := &mount{proxyRoot: cleanProxyRoot("/"), jailRoot: "/srv/www"}
+
+	tests := []struct {
+		mounts mountList
+		p      string
+		want   *mount
+	}{
+		{mountList{data, database}, "/data", data},
+		{mountList{data, database}, "/data/", data},
+		{mountList{data, database}, "/data/x", data},
+		{mountList{data, database}, "/database", database},
+		{mountList{data, database}, "/database/x", database},
+		{mountList{database, data}, "/database/x", database},
+		{mountList{data}, "/database/x", nil},
+		{mountList{data}, "/datab", nil},
+		{mountList{data, root}, "/database/x", root},
+		{mountList{data, root}, "/data/x", data},
+	}
+	for _, tt := range tests {
+		s := &Server{mounts: tt.mounts}
+		if got := s.findMount(tt.p); got != tt.want {
+			t.Errorf("findMount(%q) with %s = %v, want %v", tt.p, tt.mounts.String(), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// Whether file ownership can be determined on this platform:
+const ownerSupported = false
+
+func fileOwner(fi os.FileInfo) string {
+	return ""
+}
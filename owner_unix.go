@@ -0,0 +1,56 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Whether file ownership can be determined on this platform:
+const ownerSupported = true
+
+// Cached uid/gid to name lookups:
+var ownerNames sync.Map
+
+// Resolve a uid or gid to a name, falling back to the number itself:
+func lookupOwnerName(kind string, id uint32, lookup func(string) (string, error)) string {
+	key := kind + strconv.FormatUint(uint64(id), 10)
+	if name, ok := ownerNames.Load(key); ok {
+		return name.(string)
+	}
+
+	name := strconv.FormatUint(uint64(id), 10)
+	if n, err := lookup(name); err == nil {
+		name = n
+	}
+	ownerNames.Store(key, name)
+	return name
+}
+
+// Describe a file's owner as "user:group":
+func fileOwner(fi os.FileInfo) string {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+
+	userName := lookupOwnerName("u", st.Uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+	groupName := lookupOwnerName("g", st.Gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+	return userName + ":" + groupName
+}
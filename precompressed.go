@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Precompressed sidecar extensions by Content-Encoding, in order of preference:
+var precompressedEncodings = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Parse an Accept-Encoding header into the quality value of each listed coding, lowercased, e.g.
+// "br;q=1.0, gzip;q=0.8, identity;q=0.1". Entries with malformed q-values are ignored.
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+
+		q, ok := 1.0, true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && strings.EqualFold(param[:2], "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil || q < 0 || q > 1 {
+					ok = false
+				}
+			}
+		}
+		if ok {
+			accepted[name] = q
+		}
+	}
+	return accepted
+}
+
+// Find how much a client wants an encoding: its own q-value if listed, otherwise that of "*". identity is
+// acceptable unless excluded, e.g. by "identity;q=0" or "*;q=0", but ranks below any listed encoding when
+// unlisted; other unlisted encodings aren't acceptable.
+func encodingQuality(accepted map[string]float64, encoding string) float64 {
+	if q, ok := accepted[encoding]; ok {
+		return q
+	}
+	if q, ok := accepted["*"]; ok {
+		return q
+	}
+	if encoding == "identity" {
+		// The lowest q-value a header can express, so that ties go to listed encodings:
+		return 0.001
+	}
+	return 0
+}
+
+// Rank the encodings we can offer by the request's Accept-Encoding, leaving out unacceptable ones. Ties keep
+// the order offered, so list encodings in order of our own preference, with "identity" for no encoding.
+func rankEncodings(req *http.Request, offered []string) []string {
+	accepted := parseAcceptEncoding(req.Header.Get("Accept-Encoding"))
+
+	var ranked []string
+	for _, encoding := range offered {
+		if encodingQuality(accepted, encoding) > 0 {
+			ranked = append(ranked, encoding)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return encodingQuality(accepted, ranked[i]) > encodingQuality(accepted, ranked[j])
+	})
+	return ranked
+}
+
+// Find a precompressed variant of localPath the client accepts, returning its path and encoding:
+func findPrecompressed(req *http.Request, localPath string) (string, string) {
+	offered := make([]string, 0, len(precompressedEncodings)+1)
+	for _, pe := range precompressedEncodings {
+		offered = append(offered, pe.encoding)
+	}
+	offered = append(offered, "identity")
+
+	for _, encoding := range rankEncodings(req, offered) {
+		if encoding == "identity" {
+			// The client prefers the original to any remaining variant:
+			break
+		}
+		for _, pe := range precompressedEncodings {
+			if pe.encoding != encoding {
+				continue
+			}
+			// Only plain files next to the original; don't follow symlinks out of the jail:
+			variant := localPath + pe.ext
+			if fi, err := os.Lstat(variant); err == nil && fi.Mode().IsRegular() {
+				return variant, pe.encoding
+			}
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestParseAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   map[string]float64
+	}{
+		{"", map[string]float64{}},
+		{"gzip", map[string]float64{"gzip": 1}},
+		{"br;q=1.0, gzip;q=0.8", map[string]float64{"br": 1, "gzip": 0.8}},
+		{"GZIP;Q=0.5, Br", map[string]float64{"gzip": 0.5, "br": 1}},
+		{"gzip ; q=0.5 ,identity;q=0", map[string]float64{"gzip": 0.5, "identity": 0}},
+		{"*;q=0", map[string]float64{"*": 0}},
+		// Malformed q-values drop just their entry:
+		{"gzip;q=bogus, br", map[string]float64{"br": 1}},
+		{"gzip;q=1.5, br;q=-1, deflate;q=", map[string]float64{"deflate": 1}},
+		{" , ,gzip", map[string]float64{"gzip": 1}},
+	}
+	for _, tt := range tests {
+		got := parseAcceptEncoding(tt.header)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseAcceptEncoding(%q) = %v, want %v", tt.header, got, tt.want)
+			continue
+		}
+		for name, q := range tt.want {
+			if gq, ok := got[name]; !ok || gq != q {
+				t.Errorf("parseAcceptEncoding(%q) = %v, want %v", tt.header, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRankEncodings(t *testing.T) {
+	offered := []string{"br", "gzip", "identity"}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", "identity"},
+		{"gzip", "gzip,identity"},
+		{"gzip, br", "br,gzip,identity"},
+		{"br;q=1.0, gzip;q=0.8", "br,gzip,identity"},
+		{"br;q=1.0, gzip;q=0.8, identity;q=0.1", "br,gzip,identity"},
+		{"br;q=0.5, gzip;q=0.8", "gzip,br,identity"},
+		{"gzip;q=0.001", "gzip,identity"},
+		// Clients may prefer no encoding:
+		{"gzip;q=0.5, identity", "identity,gzip"},
+		// ...or refuse it:
+		{"identity;q=0", ""},
+		{"gzip, identity;q=0", "gzip"},
+		{"*;q=0", ""},
+		{"*;q=0, gzip", "gzip"},
+		{"*", "br,gzip,identity"},
+		{"*;q=0.5, br;q=0", "gzip,identity"},
+		{"gzip;q=0", "identity"},
+		// Header case doesn't matter, and malformed q-values are ignored:
+		{"GZIP;Q=0.9, Br;q=bogus", "gzip,identity"},
+		{"Identity;Q=0, GZip", "gzip"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Accept-Encoding", tt.header)
+		}
+		if got := strings.Join(rankEncodings(req, offered), ","); got != tt.want {
+			t.Errorf("Accept-Encoding %q: ranked %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompressListingNegotiation(t *testing.T) {
+	s := &Server{compress: true}
+	small := []byte("<html></html>")
+	large := []byte(strings.Repeat("<tr><td>entry</td></tr>\n", 100))
+
+	tests := []struct {
+		header string
+		body   []byte
+		want   string
+	}{
+		{"", large, ""},
+		{"gzip", large, "gzip"},
+		{"br", large, "br"},
+		{"gzip, br", large, "br"},
+		{"br;q=1.0, gzip;q=0.8", large, "br"},
+		{"br;q=0.5, gzip", large, "gzip"},
+		{"gzip;q=0.5, identity", large, ""},
+		{"gzip;q=0", large, ""},
+		// Small listings aren't worth compressing, unless identity is refused:
+		{"gzip", small, ""},
+		{"gzip, identity;q=0", small, "gzip"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.header)
+		if _, got := s.compressListing(req, tt.body); got != tt.want {
+			t.Errorf("Accept-Encoding %q, %d bytes: encoded %q, want %q", tt.header, len(tt.body), got, tt.want)
+		}
+	}
+
+	// Brotli output decodes back to the listing:
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	body, _ := s.compressListing(req, large)
+	if got, err := io.ReadAll(brotli.NewReader(bytes.NewReader(body))); err != nil || !bytes.Equal(got, large) {
+		t.Errorf("brotli round trip: got %d bytes, err %v", len(got), err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Determine the client's address, from the reverse proxy's headers if they're trusted:
+func (s *Server) clientAddr(req *http.Request) string {
+	if s.trustProxy {
+		if ip := strings.TrimSpace(req.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		// Use the last hop, which our proxy appended; earlier ones are whatever the client claimed:
+		if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+			hops := strings.Split(xff, ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// Determine the scheme and host the client used, from the reverse proxy's headers if they're trusted:
+func (s *Server) requestOrigin(req *http.Request) (scheme, host string) {
+	scheme, host = "http", req.Host
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	if s.trustProxy {
+		// Proxies may append their own values; the first is the one the client used:
+		if proto := firstHeaderValue(req, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if fh := firstHeaderValue(req, "X-Forwarded-Host"); fh != "" {
+			host = fh
+		}
+	}
+	return scheme, host
+}
+
+// Return the first entry of a comma-separated header:
+func firstHeaderValue(req *http.Request, name string) string {
+	return strings.TrimSpace(strings.SplitN(req.Header.Get(name), ",", 2)[0])
+}
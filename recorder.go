@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Wraps a ResponseWriter to record the status code and number of body bytes written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Check if the response has been started, so it's too late to change the status:
+func (w *statusRecorder) wroteHeader() bool {
+	return w.status != 0
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Pass flushes through to the underlying writer when supported:
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Allows http.ResponseController to reach the underlying writer:
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// The final status code; 200 if nothing was explicitly written.
+func (w *statusRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// The number of body bytes written.
+func (w *statusRecorder) Bytes() int64 {
+	return w.bytes
+}
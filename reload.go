@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+)
+
+// Flags whose files are re-read on SIGHUP, along with their -config values:
+var reloadableFlags = map[string]bool{
+	"htpasswd":       true,
+	"auth-user":      true,
+	"auth-pass":      true,
+	"error-template": true,
+	"custom-css":     true,
+	"header-html":    true,
+	"footer-html":    true,
+}
+
+// The values of the reloadable flags:
+type reloadOptions struct {
+	htpasswd      string
+	authUser      string
+	authPass      string
+	errorTemplate string
+	customCSS     string
+	headerHtml    string
+	footerHtml    string
+}
+
+// Settings loaded from files that can be swapped into a running server as a whole:
+type liveConfig struct {
+	auth          credentials
+	errorTemplate *template.Template
+	customCSS     string
+	headerHtml    string
+	footerHtml    string
+	csp           string
+}
+
+// Load the reloadable settings, failing without side effects so a bad reload leaves the old ones in place:
+func (s *Server) loadLiveConfig(o reloadOptions) (*liveConfig, error) {
+	c := &liveConfig{}
+
+	// Set up HTTP Basic authentication:
+	if o.htpasswd != "" {
+		creds, err := loadHtpasswd(o.htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		c.auth = creds
+	}
+	if o.authUser != "" || o.authPass != "" {
+		if o.authUser == "" || o.authPass == "" {
+			return nil, errors.New("-auth-user and -auth-pass must be given together")
+		}
+		if c.auth == nil {
+			c.auth = credentials{}
+		}
+		c.auth[o.authUser] = o.authPass
+	}
+
+	// Parse the error page template once:
+	if o.errorTemplate != "" {
+		t, err := template.ParseFiles(o.errorTemplate)
+		if err != nil {
+			return nil, err
+		}
+		c.errorTemplate = t
+	}
+
+	// Load the branding snippets once:
+	var err error
+	if c.customCSS, err = customCSSHtml(o.customCSS); err != nil {
+		return nil, err
+	}
+	if c.headerHtml, err = loadHtmlSnippet(o.headerHtml); err != nil {
+		return nil, err
+	}
+	if c.footerHtml, err = loadHtmlSnippet(o.footerHtml); err != nil {
+		return nil, err
+	}
+
+	c.csp = s.csp
+	if c.csp == "auto" {
+		c.csp = s.defaultCSP(o.customCSS)
+	}
+	return c, nil
+}
+
+// The current reloadable settings:
+func (s *Server) live() *liveConfig {
+	return s.liveConfig.Load().(*liveConfig)
+}
+
+// Swap in newly loaded settings, dropping listings rendered with the old ones:
+func (s *Server) setLiveConfig(c *liveConfig) {
+	s.liveConfig.Store(c)
+	if s.listingCache != nil {
+		s.listingCache.Purge()
+	}
+}
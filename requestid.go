@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// Check that a client-supplied request ID is safe to echo and log verbatim:
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Take the request ID from the -request-id-header header or generate one, echo it in the response and attach
+// it to the request for logging:
+func (s *Server) withRequestID(rsp http.ResponseWriter, req *http.Request) *http.Request {
+	if s.requestIDHeader == "" {
+		return req
+	}
+
+	id := req.Header.Get(s.requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	rsp.Header().Set(s.requestIDHeader, id)
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// The request's ID, or "" if request IDs are disabled:
+func requestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A search match, named by its path relative to the searched directory so it sorts and links as such:
+type searchResult struct {
+	os.FileInfo
+	rel string
+}
+
+func (r searchResult) Name() string {
+	return r.rel
+}
+
+// Find the files and directories under localPath whose names contain term, ignoring case. Dot-prefixed names and
+// subtrees protected by their own .index-auth file are skipped and symlinks aren't followed, so the search stays
+// within the jail. It stops early, reporting so, after -search-max-results matches or -search-timeout.
+func (s *Server) searchTree(ctx context.Context, localPath, term string) (results []os.FileInfo, stopped bool, err error) {
+	parent := ctx
+	if s.searchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.searchTimeout)
+		defer cancel()
+	}
+	term = strings.ToLower(term)
+
+	err = filepath.WalkDir(localPath, func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			stopped = true
+			return filepath.SkipAll
+		}
+		if p == localPath {
+			return err
+		}
+		if err != nil {
+			// Leave out unreadable subtrees rather than failing the whole search:
+			return nil
+		}
+
+		rel, _ := filepath.Rel(localPath, p)
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if _, err := os.Lstat(filepath.Join(p, indexAuthFile)); err == nil {
+				return filepath.SkipDir
+			}
+		}
+
+		if (d.IsDir() || d.Type().IsRegular()) && strings.Contains(strings.ToLower(d.Name()), term) {
+			if len(results) >= s.searchMaxResults {
+				stopped = true
+				return filepath.SkipAll
+			}
+			if fi, err := d.Info(); err == nil {
+				results = append(results, searchResult{fi, rel})
+			}
+		}
+
+		if d.IsDir() && strings.Count(rel, "/")+1 >= s.searchMaxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if parent.Err() != nil {
+		return nil, false, parent.Err()
+	}
+	return results, stopped, err
+}
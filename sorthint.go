@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A default sort for directories whose names match a glob:
+type sortHint struct {
+	pattern    string
+	sortString string
+}
+
+// Collects repeated -sort-hint flags of the form "glob=sort-method", e.g. "logs=date-desc":
+type sortHintList []sortHint
+
+func (l *sortHintList) String() string {
+	s := make([]string, 0, len(*l))
+	for _, h := range *l {
+		s = append(s, h.pattern+"="+h.sortString)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *sortHintList) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected glob=sort-method, got %q", value)
+	}
+	h := sortHint{pattern: value[:i], sortString: value[i+1:]}
+	if _, err := path.Match(h.pattern, ""); err != nil {
+		return fmt.Errorf("invalid glob %q: %s", h.pattern, err)
+	}
+	if _, _, ok := parseSort(h.sortString); !ok {
+		return fmt.Errorf("invalid sort %q: expected name, date or size followed by -asc or -desc", h.sortString)
+	}
+	*l = append(*l, h)
+	return nil
+}
+
+// Find the sort hinted for a directory by the first matching -sort-hint:
+func (l sortHintList) match(dirName string) (string, bool) {
+	for _, h := range l {
+		if ok, _ := path.Match(h.pattern, dirName); ok {
+			return h.sortString, true
+		}
+	}
+	return "", false
+}
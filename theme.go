@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+)
+
+// Overrides of the Bootstrap light theme for dark listings:
+const darkThemeCSS = `
+body { background-color: #1e1f22; color: #d4d4d4; }
+a, a:visited { color: #7aa7ff; }
+a:hover, a:focus { color: #a8c5ff; }
+.table-bordered, .table-bordered > thead > tr > th, .table-bordered > tbody > tr > td { border-color: #3a3c41; }
+.table-striped > tbody > tr:nth-child(odd) > td { background-color: #26282c; }
+.table-striped > tbody > tr:nth-child(even) > td { background-color: #1e1f22; }
+`
+
+// Check a -theme value:
+func validTheme(theme string) bool {
+	switch theme {
+	case "light", "dark", "auto":
+		return true
+	}
+	return false
+}
+
+// Return the extra inline CSS for a theme; "auto" follows the browser's preferred color scheme:
+func themeCSS(theme string) string {
+	switch theme {
+	case "dark":
+		return darkThemeCSS
+	case "auto":
+		return fmt.Sprintf("@media (prefers-color-scheme: dark) {%s}\n", darkThemeCSS)
+	}
+	return ""
+}
+
+// Return the color-scheme meta value for a theme so form controls and scrollbars match:
+func themeColorScheme(theme string) string {
+	switch theme {
+	case "dark":
+		return "dark"
+	case "auto":
+		return "light dark"
+	}
+	return "light"
+}
+
+// Read a flag value naming an existing file, reporting whether it was one:
+func readIfFile(value string) (string, bool, error) {
+	fi, err := os.Stat(value)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", false, nil
+	}
+	b, err := os.ReadFile(value)
+	if err != nil {
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+// Render -custom-css as either an inline stylesheet (file path) or a link (URL):
+func customCSSHtml(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	css, ok, err := readIfFile(value)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return fmt.Sprintf("    <style type=\"text/css\">\n%s\n    </style>\n", css), nil
+	}
+	return fmt.Sprintf("    <link rel=\"stylesheet\" href=\"%s\">\n", html.EscapeString(value)), nil
+}
+
+// Load -header-html/-footer-html, which are either file paths or literal HTML, ending it with a newline:
+func loadHtmlSnippet(value string) (string, error) {
+	snippet, ok, err := readIfFile(value)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		snippet = value
+	}
+	snippet = strings.TrimRight(snippet, "\r\n")
+	if snippet == "" {
+		return "", nil
+	}
+	return snippet + "\n", nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultThumbnailWidth = 200
+	// Width requested by listings, twice the displayed size for high-DPI screens:
+	listingThumbnailWidth = 128
+	// Refuse to decode images larger than this many pixels:
+	maxThumbnailSourcePixels = 64 * 1024 * 1024
+)
+
+// The widths thumbnails are generated at; only these are allowed, so clients can't fill the cache directory
+// with a thumbnail per width:
+var thumbnailWidths = []int{listingThumbnailWidth, defaultThumbnailWidth, 400, 800}
+
+func isThumbnailWidth(w int) bool {
+	for _, tw := range thumbnailWidths {
+		if w == tw {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe the allowed widths for error messages, e.g. "128, 200, 400 or 800":
+func thumbnailWidthList() string {
+	ws := make([]string, len(thumbnailWidths))
+	for i, w := range thumbnailWidths {
+		ws[i] = strconv.Itoa(w)
+	}
+	return strings.Join(ws[:len(ws)-1], ", ") + " or " + ws[len(ws)-1]
+}
+
+// Check if a file name has an extension we can decode for thumbnails:
+func isThumbnailable(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// Downscale an image to the given width with a box filter, keeping its aspect ratio:
+func scaleImage(src image.Image, w int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= w {
+		return src
+	}
+	h := sh * w / sw
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*sh/h, b.Min.Y+(y+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*sw/w, b.Min.X+(x+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// Generate a thumbnail of localPath into the cache directory unless it's already there, returning its path:
+func (s *Server) thumbnailFile(ctx context.Context, localPath string, fi os.FileInfo, w int) (string, error) {
+	// PNG and GIF sources may be transparent, so keep them lossless:
+	ext := ".jpg"
+	if e := strings.ToLower(path.Ext(localPath)); e == ".png" || e == ".gif" {
+		ext = ".png"
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d:%d", localPath, fi.ModTime().UnixNano(), fi.Size(), w)))
+	thumbPath := filepath.Join(s.thumbnailDir, hex.EncodeToString(key[:])+ext)
+	if _, err := os.Stat(thumbPath); err == nil {
+		return thumbPath, nil
+	}
+
+	// Decoding and scaling are CPU- and memory-heavy, so only a few thumbnails are generated at once:
+	select {
+	case s.thumbnailSlots <- struct{}{}:
+		defer func() { <-s.thumbnailSlots }()
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// Check the dimensions before decoding so huge images can't exhaust memory:
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxThumbnailSourcePixels {
+		return "", fmt.Errorf("image is too large to thumbnail (%dx%d)", cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+	thumb := scaleImage(img, w)
+
+	// Write to a temporary file and rename it so readers never see a partial thumbnail:
+	tmp, err := os.CreateTemp(s.thumbnailDir, "tmp-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	if ext == ".png" {
+		err = png.Encode(tmp, thumb)
+	} else {
+		err = jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: 85})
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), thumbPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return thumbPath, nil
+}
+
+// Reply with a scaled-down copy of an image, e.g. for ?thumb=1&w=200:
+func (s *Server) serveThumbnail(rsp http.ResponseWriter, req *http.Request, u *url.URL, localPath string, fi os.FileInfo) {
+	if !isThumbnailable(localPath) {
+		s.doError(req, rsp, "Not an image", http.StatusBadRequest)
+		return
+	}
+
+	w := defaultThumbnailWidth
+	if ws := u.Query().Get("w"); ws != "" {
+		n, err := strconv.Atoi(ws)
+		if err != nil || !isThumbnailWidth(n) {
+			s.doError(req, rsp, "Thumbnail width must be "+thumbnailWidthList(), http.StatusBadRequest)
+			return
+		}
+		w = n
+	}
+
+	thumbPath, err := s.thumbnailFile(req.Context(), localPath, fi, w)
+	if err != nil {
+		if err == context.Canceled {
+			// The client went away; there's nobody to reply to:
+			return
+		} else if os.IsNotExist(err) || os.IsPermission(err) {
+			s.doFileError(req, rsp, err)
+		} else {
+			s.doError(req, rsp, err.Error(), http.StatusUnprocessableEntity)
+		}
+		return
+	}
+
+	f, err := os.Open(thumbPath)
+	if err != nil {
+		s.doFileError(req, rsp, err)
+		return
+	}
+	defer f.Close()
+
+	http.ServeContent(rsp, req, thumbPath, fi.ModTime(), f)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build details, injected at build time with e.g.:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// Describe the running build, falling back to the VCS details Go embeds when no -ldflags were given:
+func versionString() string {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if rev == "" {
+					rev = setting.Value
+					if len(rev) > 12 {
+						rev = rev[:12]
+					}
+				}
+			case "vcs.time":
+				if date == "" {
+					date = setting.Value
+				}
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("index-html %s (commit %s, built %s, %s)", version, rev, date, runtime.Version())
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+type davProp struct {
+	DisplayName      string          `xml:"D:displayname"`
+	GetContentLength string          `xml:"D:getcontentlength,omitempty"`
+	GetLastModified  string          `xml:"D:getlastmodified"`
+	ResourceType     davResourceType `xml:"D:resourcetype"`
+	GetContentType   string          `xml:"D:getcontenttype,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XMLNS     string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+// Describe a file or directory as a WebDAV response element:
+func davResponseFor(href string, fi os.FileInfo) davResponse {
+	prop := davProp{
+		DisplayName:     fi.Name(),
+		GetLastModified: fi.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if fi.IsDir() {
+		prop.ResourceType.Collection = &struct{}{}
+		if !strings.HasSuffix(href, "/") {
+			href += "/"
+		}
+	} else {
+		prop.GetContentLength = strconv.FormatInt(fi.Size(), 10)
+		prop.GetContentType = mime.TypeByExtension(path.Ext(fi.Name()))
+	}
+
+	return davResponse{
+		Href: (&url.URL{Path: href}).EscapedPath(),
+		Propstat: davPropstat{
+			Prop:   prop,
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+// Answer a read-only PROPFIND request with a 207 Multi-Status listing:
+func (s *Server) servePropfind(rsp http.ResponseWriter, req *http.Request, u *url.URL, m *mount, localPath string, fi os.FileInfo) {
+	// Only finite depths are supported; a missing Depth header is treated as 1:
+	depth := req.Header.Get("Depth")
+	switch depth {
+	case "0", "1":
+	case "":
+		depth = "1"
+	default:
+		s.doError(req, rsp, "Depth must be 0 or 1", http.StatusForbidden)
+		return
+	}
+
+	ms := davMultistatus{XMLNS: "DAV:"}
+	ms.Responses = append(ms.Responses, davResponseFor(u.Path, fi))
+
+	if depth == "1" && fi.IsDir() {
+		f, err := os.Open(localPath)
+		if err != nil {
+			s.doFileError(req, rsp, err)
+			return
+		}
+		fis, err := s.readDir(req.Context(), f)
+		f.Close()
+		if err != nil {
+			s.doReadDirError(req, rsp, err)
+			return
+		}
+
+		entries, err := s.indexEntries(req, m, localPath, fis)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			// Leave out entries whose stats couldn't be resolved or that are unresolved symlinks:
+			if e.err != nil || (e.fi.Mode()&os.ModeSymlink) != 0 {
+				continue
+			}
+			r := davResponseFor(e.href, e.fi)
+			r.Propstat.Prop.DisplayName = e.name
+			ms.Responses = append(ms.Responses, r)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(buf).Encode(ms); err != nil {
+		s.doError(req, rsp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rsp.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	rsp.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	rsp.WriteHeader(http.StatusMultiStatus)
+	buf.WriteTo(rsp)
+}